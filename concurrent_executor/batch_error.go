@@ -0,0 +1,99 @@
+package concurrent_executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxErrorMessages BatchError.Error() 中最多列出的失败数，完整列表见 Failures
+const maxErrorMessages = 5
+
+// KeyError 一个 key 的错误
+type KeyError[K comparable] struct {
+	Key K
+	Err error
+}
+
+func (e *KeyError[K]) Error() string {
+	return fmt.Sprintf("key %v: %v", e.Key, e.Err)
+}
+
+func (e *KeyError[K]) Unwrap() error {
+	return e.Err
+}
+
+// BatchError 一批任务中的失败汇总，errors.Is/errors.As 可以匹配其中任意一个 key 的错误
+type BatchError[K comparable] struct {
+	Failures []*KeyError[K] // 失败的 key，与结果的顺序一致
+
+	Total     int // 任务总数
+	Succeeded int // 成功数
+	Failed    int // 失败数，包括下面的 panic、超时和取消
+	Panics    int // 任务 panic 的数量
+	Timeouts  int // 任务超时的数量
+	Canceled  int // 因 Context 取消或 FailFast 没有执行完的数量
+}
+
+func (e *BatchError[K]) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "concurrent_executor: %d of %d tasks failed (panics: %d, timeouts: %d, canceled: %d)",
+		e.Failed, e.Total, e.Panics, e.Timeouts, e.Canceled)
+	for i, f := range e.Failures {
+		if i == maxErrorMessages {
+			fmt.Fprintf(&b, "; and %d more", len(e.Failures)-maxErrorMessages)
+			break
+		}
+		b.WriteString("; ")
+		b.WriteString(f.Error())
+	}
+	return b.String()
+}
+
+func (e *BatchError[K]) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f
+	}
+	return errs
+}
+
+// NewBatchError 汇总 results 中的失败，没有失败时返回 nil
+func NewBatchError[K comparable, V any](results []ConcurrentTaskResult[K, V]) *BatchError[K] {
+	e := &BatchError[K]{Total: len(results)}
+	for _, res := range results {
+		if res.Err == nil {
+			e.Succeeded++
+			continue
+		}
+		e.Failed++
+		e.Failures = append(e.Failures, &KeyError[K]{Key: res.Key, Err: res.Err})
+
+		var panicErr *PanicError
+		switch {
+		case res.Canceled:
+			e.Canceled++
+		case errors.As(res.Err, &panicErr):
+			e.Panics++
+		case errors.Is(res.Err, context.DeadlineExceeded):
+			e.Timeouts++
+		}
+	}
+	if e.Failed == 0 {
+		return nil
+	}
+	return e
+}
+
+// batchError 按 opts.ReportErrors 和 opts.ErrorRatio 决定是否返回 *BatchError
+func batchError[K comparable, V any](opts *ExecutorOptions, results []ConcurrentTaskResult[K, V]) error {
+	if opts == nil || !opts.ReportErrors {
+		return nil
+	}
+	e := NewBatchError(results)
+	if e == nil || float64(e.Failed) < opts.ErrorRatio*float64(e.Total) {
+		return nil
+	}
+	return e
+}
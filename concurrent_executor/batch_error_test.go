@@ -0,0 +1,108 @@
+package concurrent_executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchError(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		switch {
+		case key == 1:
+			panic("boom")
+		case key == 2:
+			<-ctx.Done()
+			return 0, ctx.Err()
+		case key%3 == 0:
+			return 0, fmt.Errorf("task %d: %w", key, errInvalid)
+		}
+		return key, nil
+	}
+	keys := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	// 默认不返回错误
+	results, err := ConcurrentExecutorGeneric(context.Background(), keys, taskFunc, &ExecutorOptions{Timeout: 10 * time.Millisecond})
+	if err != nil || len(results) != len(keys) {
+		t.Fatalf("expected no error by default, got %v", err)
+	}
+
+	opts := &ExecutorOptions{Timeout: 10 * time.Millisecond, ReportErrors: true}
+	results, err = ConcurrentExecutorOrdered(context.Background(), keys, taskFunc, opts)
+	if len(results) != len(keys) {
+		t.Fatalf("results should be returned together with the error, got %d", len(results))
+	}
+	var batchErr *BatchError[int]
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	counts := []int{batchErr.Total, batchErr.Succeeded, batchErr.Failed, batchErr.Panics, batchErr.Timeouts, batchErr.Canceled}
+	if fmt.Sprint(counts) != "[10 5 5 1 1 0]" {
+		t.Errorf("expected counts total/succeeded/failed/panics/timeouts/canceled [10 5 5 1 1 0], got %v", counts)
+	}
+	var failedKeys []int
+	for _, f := range batchErr.Failures {
+		failedKeys = append(failedKeys, f.Key)
+	}
+	if fmt.Sprint(failedKeys) != "[1 2 3 6 9]" {
+		t.Errorf("expected failed keys [1 2 3 6 9], got %v", failedKeys)
+	}
+
+	// errors.Is / errors.As 匹配其中任意一个 key 的错误
+	if !errors.Is(err, errInvalid) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("batch error should wrap every failure: %v", err)
+	}
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("batch error should wrap the panic: %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "concurrent_executor: 5 of 10 tasks failed (panics: 1, timeouts: 1, canceled: 0); key 1: panic recovered: boom") {
+		t.Errorf("unexpected message: %s", err.Error())
+	}
+
+	// 失败比例低于 ErrorRatio 时不返回错误
+	opts.ErrorRatio = 0.6
+	if _, err := ConcurrentExecutorGeneric(context.Background(), keys, taskFunc, opts); err != nil {
+		t.Errorf("expected no error below the ratio, got %v", err)
+	}
+	opts.ErrorRatio = 0.5
+	if _, err := ConcurrentExecutorGeneric(context.Background(), keys, taskFunc, opts); !errors.As(err, &batchErr) {
+		t.Errorf("expected *BatchError at the ratio, got %v", err)
+	}
+}
+
+func TestBatchError_Canceled(t *testing.T) {
+	keys := make([]int, 20)
+	for i := range keys {
+		keys[i] = i
+	}
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		if key == 0 {
+			return 0, errors.New("failed")
+		}
+		return key, nil
+	}
+
+	opts := &ExecutorOptions{MaxConcurrency: 1, FailFast: true, ReportErrors: true}
+	values, err := ConcurrentExecutorMap(context.Background(), keys, taskFunc, opts)
+	if len(values) != 0 {
+		t.Errorf("expected no successful values, got %v", values)
+	}
+	var batchErr *BatchError[int]
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if batchErr.Failed != 20 || batchErr.Canceled != 19 {
+		t.Errorf("expected 20 failed and 19 canceled, got %+v", batchErr)
+	}
+	if !errors.Is(err, ErrFailFast) {
+		t.Error("batch error should wrap ErrFailFast")
+	}
+	if !strings.HasSuffix(err.Error(), "; and 15 more") {
+		t.Errorf("long failure lists should be truncated: %s", err.Error())
+	}
+}
@@ -2,23 +2,69 @@ package concurrent_executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrFailFast 开启 FailFast 后失败数达到阈值，尚未启动的任务不再执行
+var ErrFailFast = errors.New("concurrent_executor: canceled by fail-fast")
+
 // ConcurrentTaskResult 并发任务执行结果（泛型版本，支持任意 Key 和 Value 类型）
 type ConcurrentTaskResult[K comparable, V any] struct {
 	Key   K     // 任务标识（支持任意可比较类型）
 	Value V     // 任务结果（支持任意类型）
 	Err   error // 任务错误
+	// Canceled 任务因 Context 取消或 FailFast 没有启动、或执行中被中断，Err 不是任务本身的失败
+	Canceled bool
+	Attempts int // taskFunc 的调用次数，未启动的任务为 0
+}
+
+// PanicError 任务 panic 时返回的错误
+type PanicError struct {
+	Value any    // recover 得到的值
+	Stack []byte // panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic recovered: %v, stack: %s", e.Value, string(e.Stack))
 }
 
 // ExecutorOptions 并发执行器配置选项
 type ExecutorOptions struct {
 	MaxConcurrency int           // 最大并发数，0表示不限制
-	Timeout        time.Duration // 单个任务超时时间，0表示不设置超时
+	Timeout        time.Duration // 单个任务超时时间，0表示不设置超时；设置了 Retry 时为每一次尝试的超时时间
+	Retry          *RetryPolicy  // 任务失败时的重试策略，nil表示不重试
+	StreamBuffer   int           // ConcurrentExecutorStream 结果通道的缓冲大小，0表示无缓冲
+
+	// FailFast 失败数达到阈值时取消共享的 Context（类似 errgroup）：尚未启动的任务不再执行，
+	// 结果的 Err 为 ErrFailFast；执行中的任务收到取消，因此返回的结果标记为 Canceled
+	FailFast          bool
+	FailFastThreshold int     // 触发取消的失败数，默认 1 即第一个失败就取消
+	FailFastRatio     float64 // 失败数占任务总数的比例达到该值时取消，与 FailFastThreshold 同时设置时先达到的生效
+
+	// ReportErrors 有任务失败时返回 *BatchError[K]（结果仍然完整返回），默认只能通过结果的 Err 查看失败
+	ReportErrors bool
+	ErrorRatio   float64 // 失败数占任务总数的比例达到该值时才返回 *BatchError，0表示任意一个失败就返回
+}
+
+// failFastLimit 触发 FailFast 的失败数
+func (o *ExecutorOptions) failFastLimit(total int) int {
+	limit := o.FailFastThreshold
+	if o.FailFastRatio > 0 {
+		byRatio := int(math.Ceil(o.FailFastRatio * float64(total)))
+		if limit <= 0 || byRatio < limit {
+			limit = byRatio
+		}
+	}
+	if limit <= 0 {
+		limit = 1
+	}
+	return limit
 }
 
 // DefaultExecutorOptions 返回默认配置
@@ -38,17 +84,59 @@ func ConcurrentExecutorWithOptions[T any](ctx context.Context, keys []string,
 // ConcurrentExecutorGeneric 泛型并发执行器，支持任意可比较类型的Key
 // K: Key类型约束为comparable（可比较类型，如string、int、自定义struct等）
 // V: Value类型无约束，支持任意类型
+// 结果按完成顺序返回；开启 ReportErrors 时失败汇总为 *BatchError[K] 与结果一起返回
 func ConcurrentExecutorGeneric[K comparable, V any](ctx context.Context, keys []K,
 	taskFunc func(context.Context, K) (V, error), opts *ExecutorOptions) ([]ConcurrentTaskResult[K, V], error) {
 	if len(keys) == 0 {
 		return nil, fmt.Errorf("keys cannot be empty")
 	}
 
+	var mu sync.Mutex
+	results := make([]ConcurrentTaskResult[K, V], 0, len(keys))
+	execute(ctx, keys, taskFunc, opts, func(_ int, result ConcurrentTaskResult[K, V]) {
+		mu.Lock()
+		results = append(results, result)
+		mu.Unlock()
+	})
+	return results, batchError(opts, results)
+}
+
+// ConcurrentExecutorOrdered 与 ConcurrentExecutorGeneric 相同，但 results[i] 对应 keys[i]，
+// 重复的 key 各自执行、各自占一个位置
+func ConcurrentExecutorOrdered[K comparable, V any](ctx context.Context, keys []K,
+	taskFunc func(context.Context, K) (V, error), opts *ExecutorOptions) ([]ConcurrentTaskResult[K, V], error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keys cannot be empty")
+	}
+
+	// 每个下标只写入一次，不需要加锁
+	results := make([]ConcurrentTaskResult[K, V], len(keys))
+	execute(ctx, keys, taskFunc, opts, func(i int, result ConcurrentTaskResult[K, V]) {
+		results[i] = result
+	})
+	return results, batchError(opts, results)
+}
+
+// execute 执行所有任务，每个 key 的结果（包括因 Context 取消而未启动的）都通过 emit 交付一次，
+// index 为 key 在 keys 中的下标；emit 在任务所在的 goroutine 中调用，可能被并发调用，
+// emit 阻塞时任务不释放信号量，从而限制后续任务的启动。所有 emit 返回后 execute 才返回
+func execute[K comparable, V any](ctx context.Context, keys []K, taskFunc func(context.Context, K) (V, error),
+	opts *ExecutorOptions, emit func(index int, result ConcurrentTaskResult[K, V])) {
 	if opts == nil {
 		opts = DefaultExecutorOptions()
 	}
 
-	resultChan := make(chan ConcurrentTaskResult[K, V], len(keys))
+	// FailFast 时任务共享可被取消的 Context，取消原因为 ErrFailFast
+	var (
+		failures int32
+		limit    = int32(opts.failFastLimit(len(keys)))
+		cancel   context.CancelCauseFunc
+	)
+	if opts.FailFast {
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+	}
+
 	var wg sync.WaitGroup
 
 	var semaphore chan struct{}
@@ -56,15 +144,16 @@ func ConcurrentExecutorGeneric[K comparable, V any](ctx context.Context, keys []
 		semaphore = make(chan struct{}, opts.MaxConcurrency)
 	}
 
-	for _, key := range keys {
+	for i, key := range keys {
 		// 1. 优先检查 Context 是否已取消
 		// 如果 Context 已取消，不再启动新任务，直接返回错误结果
 		select {
 		case <-ctx.Done():
-			resultChan <- ConcurrentTaskResult[K, V]{
-				Key: key,
-				Err: ctx.Err(),
-			}
+			emit(i, ConcurrentTaskResult[K, V]{
+				Key:      key,
+				Err:      context.Cause(ctx),
+				Canceled: true,
+			})
 			continue
 		default:
 		}
@@ -76,78 +165,87 @@ func ConcurrentExecutorGeneric[K comparable, V any](ctx context.Context, keys []
 				// 成功获取信号量
 			case <-ctx.Done():
 				// 等待信号量期间 Context 被取消
-				resultChan <- ConcurrentTaskResult[K, V]{
-					Key: key,
-					Err: ctx.Err(),
-				}
+				emit(i, ConcurrentTaskResult[K, V]{
+					Key:      key,
+					Err:      context.Cause(ctx),
+					Canceled: true,
+				})
+				continue
+			}
+			// 释放信号量的任务可能刚刚触发了 FailFast，select 不保证优先选择 ctx.Done()
+			if ctx.Err() != nil {
+				<-semaphore
+				emit(i, ConcurrentTaskResult[K, V]{
+					Key:      key,
+					Err:      context.Cause(ctx),
+					Canceled: true,
+				})
 				continue
 			}
 		}
 
 		wg.Add(1)
 
-		go func(ctx context.Context, k K) {
-			// 标记任务是否已完成发送结果，防止重复发送导致死锁
-			var finished bool
-			defer func() {
-				defer wg.Done()
-
-				// 释放信号量
-				if semaphore != nil {
-					<-semaphore
-				}
-				// 捕获 panic，确保在发生 panic 时也能追踪到具体的 key 和错误信息
-				if r := recover(); r != nil {
-					// 只有在未发送结果的情况下才发送 panic 错误
-					// 如果已经发送了结果（finished=true）却发生了 panic（极少见，例如在发送后的清理逻辑中），
-					// 则不再发送结果，避免 resultChan 阻塞导致死锁
-					if !finished {
-						err := fmt.Errorf("panic recovered: %v, stack: %s", r, string(debug.Stack()))
-						resultChan <- ConcurrentTaskResult[K, V]{
-							Key: k,
-							Err: err,
-						}
-					} else {
-						// 记录日志或忽略，因为结果已发送
-						fmt.Printf("panic recovered after result sent: %v\n", r)
-					}
-				}
-			}()
-
-			// 如果设置了超时，添加超时控制
-			var execCtx = ctx
-			if opts.Timeout > 0 {
-				var cancel context.CancelFunc
-				execCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
-				defer cancel()
-			}
-			value, err := taskFunc(execCtx, k)
-			// 无论成功还是失败，都返回结果，确保结果集完整
-			resultChan <- ConcurrentTaskResult[K, V]{
-				Key:   k,
-				Value: value,
-				Err:   err,
+		go func(ctx context.Context, i int, k K) {
+			defer wg.Done()
+			// 结果交付之后再释放信号量
+			if semaphore != nil {
+				defer func() { <-semaphore }()
 			}
-			finished = true
 
-		}(ctx, key)
+			var (
+				value    V
+				err      error
+				attempts = 1
+			)
+			if opts.Retry != nil {
+				value, attempts, err = runWithRetry(ctx, k, taskFunc, opts)
+			} else {
+				value, err = runTask(ctx, k, taskFunc, opts)
+			}
+			// 任务因共享的 Context 被取消而返回的错误不算失败
+			canceled := err != nil && ctx.Err() != nil && errors.Is(err, context.Canceled)
+			if err != nil && !canceled && opts.FailFast && atomic.AddInt32(&failures, 1) >= limit {
+				cancel(ErrFailFast)
+			}
+			// 无论成功还是失败，都返回结果，确保结果集完整
+			emit(i, ConcurrentTaskResult[K, V]{
+				Key:      k,
+				Value:    value,
+				Err:      err,
+				Canceled: canceled,
+				Attempts: attempts,
+			})
+		}(ctx, i, key)
 	}
 	wg.Wait()
-	close(resultChan)
+}
 
-	// 收集结果
-	results := make([]ConcurrentTaskResult[K, V], 0, len(keys))
-	for result := range resultChan {
-		results = append(results, result)
-	}
+// runTask 执行一次任务，任务 panic 时转换为 *PanicError
+func runTask[K comparable, V any](ctx context.Context, k K, taskFunc func(context.Context, K) (V, error),
+	opts *ExecutorOptions) (value V, err error) {
+	// 捕获 panic，确保在发生 panic 时也能追踪到具体的 key 和错误信息
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
 
-	return results, nil
+	// 如果设置了超时，添加超时控制
+	var execCtx = ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	return taskFunc(execCtx, k)
 }
 
 // ConcurrentExecutorMap 并发执行器，返回Map结果（方便按Key查找）
+// 开启 ReportErrors 时返回成功的结果和 *BatchError[K]
 func ConcurrentExecutorMap[K comparable, V any](ctx context.Context, keys []K, taskFunc func(context.Context, K) (V, error), opts *ExecutorOptions) (map[K]V, error) {
 	results, err := ConcurrentExecutorGeneric(ctx, keys, taskFunc, opts)
-	if err != nil {
+	if results == nil {
 		return nil, err
 	}
 
@@ -158,5 +256,27 @@ func ConcurrentExecutorMap[K comparable, V any](ctx context.Context, keys []K, t
 		}
 	}
 
+	return resultMap, err
+}
+
+// ConcurrentExecutorMapWithErrors 与 ConcurrentExecutorMap 相同，但失败的 key 不会被静默丢弃：
+// 返回的 map 只包含成功的 key，有任何 key 失败时（不受 ReportErrors 和 ErrorRatio 影响）
+// 返回按 keys 顺序列出失败的 *BatchError[K]，全部成功时为 nil
+func ConcurrentExecutorMapWithErrors[K comparable, V any](ctx context.Context, keys []K,
+	taskFunc func(context.Context, K) (V, error), opts *ExecutorOptions) (map[K]V, error) {
+	results, err := ConcurrentExecutorOrdered(ctx, keys, taskFunc, opts)
+	if results == nil {
+		return nil, err
+	}
+
+	resultMap := make(map[K]V, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			resultMap[result.Key] = result.Value
+		}
+	}
+	if e := NewBatchError(results); e != nil {
+		return resultMap, e
+	}
 	return resultMap, nil
 }
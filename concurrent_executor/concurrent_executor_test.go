@@ -2,8 +2,10 @@ package concurrent_executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -167,3 +169,102 @@ func TestConcurrentExecutorGeneric_Cancel(t *testing.T) {
 		t.Errorf("expected executedCount to be 1, got %d", executedCount)
 	}
 }
+
+func TestConcurrentExecutorGeneric_FailFast(t *testing.T) {
+	keys := []string{"task1", "task2", "task3", "task4", "task5"}
+	opts := &ExecutorOptions{MaxConcurrency: 2, FailFast: true}
+
+	var executedCount int32
+	taskFunc := func(ctx context.Context, key string) (string, error) {
+		atomic.AddInt32(&executedCount, 1)
+		switch key {
+		case "task1":
+			return "", errors.New("downstream unavailable")
+		case "task2":
+			// 执行中的任务收到取消
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Second):
+				return "done", nil
+			}
+		}
+		return "should_not_run", nil
+	}
+
+	results, err := ConcurrentExecutorGeneric(context.Background(), keys, taskFunc, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(results))
+	}
+
+	for _, res := range results {
+		switch res.Key {
+		case "task1":
+			if res.Err == nil || res.Canceled {
+				t.Errorf("task1 should be reported as failed, got err=%v canceled=%v", res.Err, res.Canceled)
+			}
+		case "task2":
+			if !errors.Is(res.Err, context.Canceled) || !res.Canceled {
+				t.Errorf("task2 should be reported as canceled, got err=%v canceled=%v", res.Err, res.Canceled)
+			}
+		default:
+			if !errors.Is(res.Err, ErrFailFast) || !res.Canceled {
+				t.Errorf("%s should not start after fail-fast, got err=%v canceled=%v", res.Key, res.Err, res.Canceled)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&executedCount); n != 2 {
+		t.Errorf("expected 2 tasks executed, got %d", n)
+	}
+}
+
+func TestConcurrentExecutorGeneric_FailFastThreshold(t *testing.T) {
+	keys := make([]int, 10)
+	for i := range keys {
+		keys[i] = i
+	}
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		if key%2 == 0 {
+			return 0, fmt.Errorf("task %d failed", key)
+		}
+		return key, nil
+	}
+
+	tests := []struct {
+		name     string
+		opts     *ExecutorOptions
+		failures int
+	}{
+		{"Threshold", &ExecutorOptions{MaxConcurrency: 1, FailFast: true, FailFastThreshold: 3}, 3},
+		{"Ratio", &ExecutorOptions{MaxConcurrency: 1, FailFast: true, FailFastRatio: 0.2}, 2},
+		{"EarliestWins", &ExecutorOptions{MaxConcurrency: 1, FailFast: true, FailFastThreshold: 4, FailFastRatio: 0.3}, 3},
+		{"Disabled", &ExecutorOptions{MaxConcurrency: 1, FailFastThreshold: 1}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := ConcurrentExecutorGeneric(context.Background(), keys, taskFunc, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var failed, canceled int
+			for _, res := range results {
+				switch {
+				case res.Canceled:
+					canceled++
+				case res.Err != nil:
+					failed++
+				}
+			}
+			if failed != tt.failures {
+				t.Errorf("expected %d failures, got %d", tt.failures, failed)
+			}
+			// 串行执行时，最后一个失败之后的任务全部被取消
+			if want := len(keys) - (2*tt.failures - 1); tt.opts.FailFast && canceled != want {
+				t.Errorf("expected %d canceled tasks, got %d", want, canceled)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package concurrent_executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrentExecutorOrdered(t *testing.T) {
+	keys := []int{5, 4, 3, 2, 1, 3}
+	taskFunc := func(ctx context.Context, key int) (string, error) {
+		// 越靠前的任务完成得越晚
+		time.Sleep(time.Duration(key) * 5 * time.Millisecond)
+		if key == 2 {
+			return "", errors.New("failed")
+		}
+		return fmt.Sprintf("value-%d", key), nil
+	}
+
+	results, err := ConcurrentExecutorOrdered(context.Background(), keys, taskFunc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(results))
+	}
+	for i, res := range results {
+		if res.Key != keys[i] {
+			t.Errorf("results[%d]: expected key %d, got %d", i, keys[i], res.Key)
+		}
+		if keys[i] == 2 {
+			if res.Err == nil {
+				t.Errorf("results[%d]: expected error", i)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("value-%d", keys[i]); res.Value != want || res.Err != nil {
+			t.Errorf("results[%d]: expected %s, got %s (err=%v)", i, want, res.Value, res.Err)
+		}
+	}
+
+	if _, err := ConcurrentExecutorOrdered(context.Background(), []int{}, taskFunc, nil); err == nil {
+		t.Error("expected error for empty keys")
+	}
+}
+
+func TestConcurrentExecutorMapWithErrors(t *testing.T) {
+	errNotFound := errors.New("not found")
+	taskFunc := func(ctx context.Context, key string) (int, error) {
+		if strings.HasPrefix(key, "missing") {
+			return 0, errNotFound
+		}
+		return len(key), nil
+	}
+
+	values, err := ConcurrentExecutorMapWithErrors(context.Background(), []string{"a", "missing-1", "bbb", "missing-2"}, taskFunc, nil)
+	if len(values) != 2 || values["a"] != 1 || values["bbb"] != 3 {
+		t.Errorf("unexpected values: %v", values)
+	}
+	if !errors.Is(err, errNotFound) {
+		t.Fatalf("expected aggregated error to wrap errNotFound, got %v", err)
+	}
+	var batchErr *BatchError[string]
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %T", err)
+	}
+	if len(batchErr.Failures) != 2 || batchErr.Failures[0].Key != "missing-1" || batchErr.Failures[1].Key != "missing-2" {
+		t.Errorf("expected failures listed in input order, got %v", batchErr.Failures)
+	}
+
+	values, err = ConcurrentExecutorMapWithErrors(context.Background(), []string{"a", "bb"}, taskFunc, nil)
+	if err != nil || len(values) != 2 {
+		t.Errorf("expected all keys to succeed, got %v, %v", values, err)
+	}
+}
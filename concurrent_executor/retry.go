@@ -0,0 +1,89 @@
+package concurrent_executor
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 单个任务的重试策略，每个 key 独立重试
+// ExecutorOptions.Timeout 限制每一次尝试，TotalTimeout 限制同一个 key 的所有尝试和等待
+type RetryPolicy struct {
+	MaxAttempts    int              // 最大尝试次数（包括第一次），0或1表示不重试
+	InitialBackoff time.Duration    // 第一次重试前的等待时间，默认 100ms
+	MaxBackoff     time.Duration    // 等待时间上限，默认 10s
+	Multiplier     float64          // 每次重试等待时间的倍数，默认 2
+	Jitter         float64          // 等待时间的随机浮动比例（0~1），例如 0.2 表示在 ±20% 范围内浮动
+	Retryable      func(error) bool // 判断错误是否可以重试，nil 表示除 panic 外的错误都重试
+	TotalTimeout   time.Duration    // 同一个 key 所有尝试的总时长，0表示不限制
+}
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultMultiplier     = 2
+)
+
+// shouldRetry 第 attempt 次尝试返回 err 后是否继续重试
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	// panic 通常是程序错误，重试没有意义
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// backoff 第 attempt 次尝试失败后的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, maxBackoff, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+
+	delay := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxBackoff))
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay *= 1 - jitter + 2*jitter*rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// runWithRetry 按重试策略执行任务，返回最后一次尝试的结果和尝试次数
+func runWithRetry[K comparable, V any](ctx context.Context, k K, taskFunc func(context.Context, K) (V, error),
+	opts *ExecutorOptions) (value V, attempts int, err error) {
+	policy := opts.Retry
+	if policy.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.TotalTimeout)
+		defer cancel()
+	}
+
+	for {
+		attempts++
+		value, err = runTask(ctx, k, taskFunc, opts)
+		if !policy.shouldRetry(attempts, err) || ctx.Err() != nil {
+			return value, attempts, err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempts))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// 等待期间被取消或超出总时长，返回最后一次尝试的错误
+			timer.Stop()
+			return value, attempts, err
+		}
+	}
+}
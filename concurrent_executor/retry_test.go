@@ -0,0 +1,135 @@
+package concurrent_executor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentExecutorGeneric_Retry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errInvalid := errors.New("invalid key")
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	taskFunc := func(ctx context.Context, key string) (string, error) {
+		mu.Lock()
+		calls[key]++
+		n := calls[key]
+		mu.Unlock()
+
+		switch key {
+		case "flaky":
+			// 前两次失败，第三次成功
+			if n < 3 {
+				return "", errTransient
+			}
+		case "down":
+			return "", errTransient
+		case "invalid":
+			return "", errInvalid
+		case "panic":
+			panic("boom")
+		}
+		return key, nil
+	}
+
+	opts := &ExecutorOptions{
+		Retry: &RetryPolicy{
+			MaxAttempts:    4,
+			InitialBackoff: time.Millisecond,
+			Retryable:      func(err error) bool { return !errors.Is(err, errInvalid) },
+		},
+	}
+	results, err := ConcurrentExecutorGeneric(context.Background(), []string{"ok", "flaky", "down", "invalid", "panic"}, taskFunc, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]struct {
+		attempts int
+		err      error
+	}{
+		"ok":      {1, nil},
+		"flaky":   {3, nil},
+		"down":    {4, errTransient},
+		"invalid": {1, errInvalid},
+		"panic":   {1, nil},
+	}
+	for _, res := range results {
+		w := want[res.Key]
+		if res.Attempts != w.attempts || calls[res.Key] != w.attempts {
+			t.Errorf("%s: expected %d attempts, got %d (%d calls)", res.Key, w.attempts, res.Attempts, calls[res.Key])
+		}
+		if res.Key == "panic" {
+			var panicErr *PanicError
+			if !errors.As(res.Err, &panicErr) || panicErr.Value != "boom" {
+				t.Errorf("panic: expected *PanicError, got %v", res.Err)
+			}
+			continue
+		}
+		if !errors.Is(res.Err, w.err) || (w.err == nil && res.Err != nil) {
+			t.Errorf("%s: expected error %v, got %v", res.Key, w.err, res.Err)
+		}
+	}
+}
+
+func TestConcurrentExecutorGeneric_RetryTimeouts(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	taskFunc := func(ctx context.Context, key string) (string, error) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	// Timeout 限制每一次尝试，TotalTimeout 限制所有尝试
+	opts := &ExecutorOptions{
+		Timeout: 20 * time.Millisecond,
+		Retry: &RetryPolicy{
+			MaxAttempts:    100,
+			InitialBackoff: 10 * time.Millisecond,
+			TotalTimeout:   100 * time.Millisecond,
+		},
+	}
+	start := time.Now()
+	results, err := ConcurrentExecutorGeneric(context.Background(), []string{"slow"}, taskFunc, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("total timeout should stop retries, took %v", elapsed)
+	}
+
+	res := results[0]
+	if !errors.Is(res.Err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", res.Err)
+	}
+	if res.Attempts < 2 || res.Attempts >= 10 {
+		t.Errorf("expected a few attempts within the total timeout, got %d", res.Attempts)
+	}
+	if res.Attempts != attempts {
+		t.Errorf("expected Attempts %d to match calls %d", res.Attempts, attempts)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, w, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %v", got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package concurrent_executor
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultStreamConcurrency ConcurrentExecutorStream 未设置 MaxConcurrency 时的并发数
+const DefaultStreamConcurrency = 16
+
+// ConcurrentExecutorStream 流式并发执行器，任务完成后立即通过返回的通道交付结果，不在内存中保留全部结果
+//
+// 背压：调用方读取慢时，任务 goroutine 阻塞在发送结果上且不释放并发名额，
+// 同时在途的任务和结果最多 MaxConcurrency + StreamBuffer 个；MaxConcurrency 为 0 时使用 DefaultStreamConcurrency，
+// 流式执行不支持不限并发
+//
+// 关闭：所有结果交付后通道关闭。调用方不再读取时必须取消 ctx：
+// 取消后不再启动新任务，尚未送达的结果被丢弃，正在执行的任务结束后通道关闭，不会泄漏 goroutine；
+// 因此 ctx 取消后继续读取只能收到部分结果
+func ConcurrentExecutorStream[K comparable, V any](ctx context.Context, keys []K,
+	taskFunc func(context.Context, K) (V, error), opts *ExecutorOptions) (<-chan ConcurrentTaskResult[K, V], error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keys cannot be empty")
+	}
+	if opts == nil {
+		opts = DefaultExecutorOptions()
+	}
+	if opts.MaxConcurrency <= 0 {
+		bounded := *opts
+		bounded.MaxConcurrency = DefaultStreamConcurrency
+		opts = &bounded
+	}
+
+	out := make(chan ConcurrentTaskResult[K, V], opts.StreamBuffer)
+	go func() {
+		defer close(out)
+		execute(ctx, keys, taskFunc, opts, func(_ int, result ConcurrentTaskResult[K, V]) {
+			select {
+			case out <- result:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return out, nil
+}
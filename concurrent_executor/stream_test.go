@@ -0,0 +1,164 @@
+package concurrent_executor
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentExecutorStream_DeliversAsCompleted(t *testing.T) {
+	keys := []int{1, 2, 3, 4, 5}
+	release := make(chan struct{})
+
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		if key == 1 {
+			// 最慢的任务不应该阻塞其他结果的交付
+			<-release
+		}
+		return key * 10, nil
+	}
+
+	stream, err := ConcurrentExecutorStream(context.Background(), keys, taskFunc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	seen := make(map[int]int)
+	for i := 0; i < len(keys)-1; i++ {
+		select {
+		case res := <-stream:
+			if res.Err != nil {
+				t.Fatalf("unexpected error for key %d: %v", res.Key, res.Err)
+			}
+			seen[res.Key] = res.Value
+		case <-time.After(time.Second):
+			t.Fatal("results should be delivered before the slowest task finishes")
+		}
+	}
+	if _, ok := seen[1]; ok {
+		t.Fatal("slow task should not have completed yet")
+	}
+
+	close(release)
+	for res := range stream {
+		seen[res.Key] = res.Value
+	}
+	if len(seen) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(seen))
+	}
+	for _, k := range keys {
+		if seen[k] != k*10 {
+			t.Errorf("key %d: expected %d, got %d", k, k*10, seen[k])
+		}
+	}
+}
+
+func TestConcurrentExecutorStream_Backpressure(t *testing.T) {
+	keys := make([]int, 20)
+	for i := range keys {
+		keys[i] = i
+	}
+	var started int32
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		atomic.AddInt32(&started, 1)
+		return key, nil
+	}
+
+	opts := &ExecutorOptions{MaxConcurrency: 2, StreamBuffer: 3}
+	stream, err := ConcurrentExecutorStream(context.Background(), keys, taskFunc, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 不读取结果时，最多启动 缓冲大小 + 并发数 个任务
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&started); n != 5 {
+		t.Errorf("expected 5 tasks started while the consumer is idle, got %d", n)
+	}
+
+	var count int
+	for range stream {
+		count++
+	}
+	if count != len(keys) {
+		t.Errorf("expected %d results, got %d", len(keys), count)
+	}
+}
+
+func TestConcurrentExecutorStream_CancelShutsDown(t *testing.T) {
+	keys := make([]int, 1000)
+	for i := range keys {
+		keys[i] = i
+	}
+	var started int32
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		atomic.AddInt32(&started, 1)
+		select {
+		case <-time.After(time.Millisecond):
+			return key, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := ConcurrentExecutorStream(ctx, keys, taskFunc, &ExecutorOptions{MaxConcurrency: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	<-stream
+	cancel()
+
+	// 调用方不再读取，通道仍然会关闭
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		for range stream {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream should be closed after the context is canceled")
+	}
+
+	if n := atomic.LoadInt32(&started); n >= int32(len(keys)) {
+		t.Errorf("expected remaining tasks to be skipped after cancel, %d started", n)
+	}
+}
+
+func TestConcurrentExecutorStream_DefaultConcurrency(t *testing.T) {
+	keys := make([]int, 1000)
+	for i := range keys {
+		keys[i] = i
+	}
+	var started int32
+	taskFunc := func(ctx context.Context, key int) (int, error) {
+		atomic.AddInt32(&started, 1)
+		return key, nil
+	}
+
+	// 未设置 MaxConcurrency 时同样有背压，调用方不读取时不会启动全部任务
+	stream, err := ConcurrentExecutorStream(context.Background(), keys, taskFunc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&started); n != DefaultStreamConcurrency {
+		t.Errorf("expected %d tasks started while the consumer is idle, got %d", DefaultStreamConcurrency, n)
+	}
+	var count int
+	for range stream {
+		count++
+	}
+	if count != len(keys) {
+		t.Errorf("expected %d results, got %d", len(keys), count)
+	}
+
+	if _, err := ConcurrentExecutorStream(context.Background(), []int{}, taskFunc, nil); err == nil {
+		t.Error("expected error for empty keys")
+	}
+}
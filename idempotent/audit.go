@@ -0,0 +1,181 @@
+package idempotent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcome 一次幂等调用的结果
+type Outcome string
+
+const (
+	// OutcomeExecuted 首次请求，处理成功并保存了结果
+	OutcomeExecuted Outcome = "executed"
+	// OutcomeFailed 首次请求，处理失败
+	OutcomeFailed Outcome = "failed"
+	// OutcomeReplayed 重复请求，返回了之前保存的结果（包括被缓存的失败）
+	OutcomeReplayed Outcome = "replayed"
+	// OutcomeInProgress 重复请求，首次请求仍在处理中
+	OutcomeInProgress Outcome = "in_progress"
+	// OutcomeMismatch 幂等键被用于内容不同的请求
+	OutcomeMismatch Outcome = "mismatch"
+	// OutcomeCanceled 等待结果时 ctx 被取消
+	OutcomeCanceled Outcome = "canceled"
+	// OutcomeStoreError 访问存储出错
+	OutcomeStoreError Outcome = "store_error"
+)
+
+// AuditEvent 一次幂等调用的审计记录
+type AuditEvent struct {
+	Key       string        `json:"key"`
+	Operation string        `json:"operation,omitempty"`
+	Time      time.Time     `json:"time"`
+	Outcome   Outcome       `json:"outcome"`
+	Latency   time.Duration `json:"latency"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// AuditSink 审计记录的存放位置，Record 在请求路径上同步调用，实现应当尽快返回
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// AuditReader 按幂等键查询审计记录，按记录时间先后返回
+type AuditReader interface {
+	History(ctx context.Context, key string) ([]AuditEvent, error)
+}
+
+// KeyHistory 一个幂等键的汇总信息
+type KeyHistory struct {
+	Key         string
+	Operation   string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Executions  int // 实际执行处理逻辑的次数，大于 1 说明失败后被重试
+	ReplayCount int
+	Conflicts   int // 遇到处理中或内容不一致被拒绝的次数
+	LastOutcome Outcome
+}
+
+// Summarize 汇总同一个幂等键的审计记录
+func Summarize(key string, events []AuditEvent) KeyHistory {
+	h := KeyHistory{Key: key}
+	for _, e := range events {
+		if h.FirstSeen.IsZero() || e.Time.Before(h.FirstSeen) {
+			h.FirstSeen = e.Time
+		}
+		if e.Time.After(h.LastSeen) {
+			h.LastSeen = e.Time
+		}
+		if e.Operation != "" {
+			h.Operation = e.Operation
+		}
+		switch e.Outcome {
+		case OutcomeExecuted, OutcomeFailed:
+			h.Executions++
+		case OutcomeReplayed:
+			h.ReplayCount++
+		case OutcomeInProgress, OutcomeMismatch:
+			h.Conflicts++
+		}
+		h.LastOutcome = e.Outcome
+	}
+	return h
+}
+
+// MemoryAuditSink 保存在内存中的审计记录，适合单元测试
+type MemoryAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+// NewMemoryAuditSink 创建内存审计记录
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+func (s *MemoryAuditSink) Record(_ context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemoryAuditSink) History(_ context.Context, key string) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []AuditEvent
+	for _, e := range s.events {
+		if e.Key == key {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// FileAuditSink 以 JSON Lines 格式追加写入文件的审计记录
+type FileAuditSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileAuditSink 审计记录追加写入 path，文件不存在时创建
+func NewFileAuditSink(path string) *FileAuditSink {
+	return &FileAuditSink{path: path}
+}
+
+func (s *FileAuditSink) Record(_ context.Context, event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileAuditSink) History(ctx context.Context, key string) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// 跳过写入时被截断的行
+			continue
+		}
+		if e.Key == key {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,97 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard_AuditAndMetrics(t *testing.T) {
+	ctx := context.Background()
+	sink := NewMemoryAuditSink()
+	store := NewMemoryStore()
+	g := NewGuard(store, WithAuditSink(sink))
+
+	fail := true
+	fn := func(ctx context.Context) (string, error) {
+		if fail {
+			return "", errors.New("downstream timeout")
+		}
+		return "ok", nil
+	}
+	exec := func(key string, opts ...ExecuteOption) error {
+		_, err := Execute(ctx, key, fn, append([]ExecuteOption{WithGuard(g), WithOperation("pay")}, opts...)...)
+		return err
+	}
+
+	assert.Error(t, exec("pay-1"))
+	fail = false
+	assert.NoError(t, exec("pay-1"))
+	assert.NoError(t, exec("pay-1"))
+	assert.NoError(t, exec("pay-1"))
+
+	// 处理中的冲突和内容不一致
+	_, err := store.SetNX(ctx, "pay-2", record{State: StateInProgress, Fingerprint: "a"}.encode(), time.Minute)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, exec("pay-2", WithFingerprint("a")), ErrRequestInProgress)
+	assert.ErrorIs(t, exec("pay-2", WithFingerprint("b")), ErrFingerprintMismatch)
+
+	assert.Equal(t, MetricsSnapshot{Hits: 2, Misses: 2, Conflicts: 1, Mismatches: 1}, g.Metrics().Snapshot())
+	assert.JSONEq(t, `{"hits":2,"misses":2,"conflicts":1,"mismatches":1,"storeErrors":0}`, g.Metrics().String())
+
+	events, err := sink.History(ctx, "pay-1")
+	assert.NoError(t, err)
+	outcomes := make([]Outcome, len(events))
+	for i, e := range events {
+		outcomes[i] = e.Outcome
+		assert.Equal(t, "pay", e.Operation)
+	}
+	assert.Equal(t, []Outcome{OutcomeFailed, OutcomeExecuted, OutcomeReplayed, OutcomeReplayed}, outcomes)
+	assert.Equal(t, "downstream timeout", events[0].Error)
+
+	h := Summarize("pay-1", events)
+	assert.Equal(t, events[0].Time, h.FirstSeen)
+	assert.Equal(t, 2, h.Executions)
+	assert.Equal(t, 2, h.ReplayCount)
+	assert.Equal(t, OutcomeReplayed, h.LastOutcome)
+}
+
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) SetNX(context.Context, string, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestGuard_StoreErrorMetric(t *testing.T) {
+	g := NewGuard(failingStore{NewMemoryStore()})
+	_, err := g.Process(context.Background(), "order-1")
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), g.Metrics().Snapshot().StoreErrors)
+}
+
+func TestFileAuditSink(t *testing.T) {
+	ctx := context.Background()
+	sink := NewFileAuditSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	events, err := sink.History(ctx, "order-1")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	now := time.Now().Truncate(time.Millisecond)
+	assert.NoError(t, sink.Record(ctx, AuditEvent{Key: "order-1", Time: now, Outcome: OutcomeExecuted, Latency: time.Second}))
+	assert.NoError(t, sink.Record(ctx, AuditEvent{Key: "order-2", Time: now, Outcome: OutcomeExecuted}))
+	assert.NoError(t, sink.Record(ctx, AuditEvent{Key: "order-1", Time: now.Add(time.Second), Outcome: OutcomeReplayed}))
+
+	events, err = sink.History(ctx, "order-1")
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.True(t, now.Equal(events[0].Time))
+	assert.Equal(t, time.Second, events[0].Latency)
+	assert.Equal(t, OutcomeReplayed, events[1].Outcome)
+}
@@ -0,0 +1,336 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+
+	"go-examples/concurrent_executor"
+)
+
+// BatchResult ProcessBatch 中一项的结果
+type BatchResult[T any] struct {
+	Key   string
+	Value T
+	Err   error
+	// Replayed 该项之前已处理过，Value/Err 为之前保存的结果；首次处理仍在进行中时 Err 为 ErrRequestInProgress
+	Replayed bool
+}
+
+// WithBatchExecutor ProcessBatch 通过 concurrent_executor 并发执行需要处理的条目，默认逐个执行
+func WithBatchExecutor(opts *concurrent_executor.ExecutorOptions) ExecuteOption {
+	return func(o *executeOptions) {
+		o.batchExecutor = opts
+		if o.batchExecutor == nil {
+			o.batchExecutor = concurrent_executor.DefaultExecutorOptions()
+		}
+	}
+}
+
+// ProcessBatch 以批为单位的幂等处理，适合每一行都有自然键的批量导入：
+//  1. 一次往返认领所有 key（存储实现了 BatchStore 时使用 pipeline）
+//  2. 只对认领成功的 key 执行 fn，已处理过的 key 直接返回之前保存的结果
+//  3. 一次往返保存所有结果，可重试的失败释放认领，下一批可以重新处理
+//
+// 结果按 keys 中首次出现的顺序返回，重复的 key 只处理一次；
+// 认领阶段访问存储失败时返回 error，保存结果失败时对应条目的 Err 为存储错误（Value 仍为本次的处理结果）；
+// 并发执行并开启 ExecutorOptions.ReportErrors 时，失败、超时或被取消的条目汇总为 *concurrent_executor.BatchError[string]
+// （Key 为幂等键）与完整的结果一起返回
+func ProcessBatch[T any](ctx context.Context, keys []string, fn func(ctx context.Context, key string) (T, error),
+	opts ...ExecuteOption) ([]BatchResult[T], error) {
+	o := executeOptions{codec: JSONCodec}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.guard == nil {
+		o.guard = DefaultGuard()
+	}
+	g := o.guard
+	p := g.policy(o.operation)
+	start := time.Now()
+
+	keys = uniqueKeys(keys)
+	results := make([]BatchResult[T], len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	// 1. 批量认领
+	claims := make([]string, len(keys))
+	for i := range keys {
+		claims[i] = record{State: StateInProgress, Owner: uuid.New().String()}.encode()
+	}
+	claimed, err := setNXBatch(ctx, g.store, keys, claims, p.LeaseDuration)
+	if err != nil {
+		// 无法确定出错前哪些 key 已经认领成功；认领值是唯一的，按值删除只会释放本批认领的 key
+		all := make([]int, len(keys))
+		for i := range all {
+			all[i] = i
+		}
+		releaseClaims(ctx, g.store, keys, claims, all)
+		g.observeStoreError(ctx, keys, o.operation, start, err)
+		return nil, err
+	}
+
+	// 2. 未认领成功的 key 读取已有记录
+	var (
+		pending []int // 需要执行的条目下标
+		others  []string
+	)
+	index := make(map[string]int, len(keys))
+	for i, key := range keys {
+		index[key] = i
+		results[i].Key = key
+		if claimed[i] {
+			pending = append(pending, i)
+		} else {
+			others = append(others, key)
+		}
+	}
+	if len(others) > 0 {
+		existing, err := getBatch(ctx, g.store, others)
+		if err != nil {
+			// 已认领的条目还没有执行，释放后整批失败
+			releaseClaims(ctx, g.store, keys, claims, pending)
+			g.observeStoreError(ctx, keys, o.operation, start, err)
+			return nil, err
+		}
+		for _, key := range others {
+			r := &results[index[key]]
+			r.Replayed = true
+			raw, ok := existing[key]
+			if !ok {
+				// 认领之后记录刚好过期或被释放，交给下一批处理
+				r.Err = ErrRequestInProgress
+				continue
+			}
+			rec := decodeRecord(raw)
+			if rec.State != StateDone {
+				r.Err = ErrRequestInProgress
+				continue
+			}
+			data, err := rec.replay()
+			if err != nil {
+				r.Err = err
+				continue
+			}
+			r.Err = o.codec.Unmarshal([]byte(data), &r.Value)
+		}
+	}
+
+	// 3. 执行认领成功的条目
+	var execErr error
+	encoded := make([]string, len(keys))
+	run := func(ctx context.Context, i int) (T, error) {
+		value, err := fn(ctx, keys[i])
+		if err != nil {
+			return value, err
+		}
+		data, err := o.codec.Marshal(value)
+		if err != nil {
+			return value, err
+		}
+		encoded[i] = string(data)
+		return value, nil
+	}
+	if o.batchExecutor == nil {
+		for _, i := range pending {
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				continue
+			}
+			results[i].Value, results[i].Err = runRecovered(ctx, i, run)
+		}
+	} else if len(pending) > 0 {
+		executed, err := concurrent_executor.ConcurrentExecutorGeneric(ctx, pending, run, o.batchExecutor)
+		for _, res := range executed {
+			results[res.Key].Value, results[res.Key].Err = res.Value, res.Err
+		}
+		execErr = batchKeyError(keys, err)
+	}
+
+	// 4. 批量保存结果、释放失败的条目
+	var (
+		saves, releases []SwapEntry
+		saved           []int
+	)
+	for _, i := range pending {
+		done, release := finish(keys[i], encoded[i], results[i].Err, p, &o)
+		if release {
+			releases = append(releases, SwapEntry{Key: keys[i], Old: claims[i]})
+			continue
+		}
+		saves = append(saves, SwapEntry{Key: keys[i], Old: claims[i], Value: done.encode()})
+		saved = append(saved, i)
+	}
+	if len(releases) > 0 {
+		if _, err := compareAndDeleteBatch(ctx, g.store, releases); err != nil {
+			log.Printf("idempotent: release %d batch claims failed: %v", len(releases), err)
+		}
+	}
+	saveFailed := make([]bool, len(keys))
+	if len(saves) > 0 {
+		stored, err := compareAndSwapBatch(ctx, g.store, saves, resultTTL(p, &o))
+		for n, i := range saved {
+			switch {
+			case err != nil:
+				saveFailed[i] = true
+				if results[i].Err == nil {
+					results[i].Err = err
+				}
+			case !stored[n]:
+				log.Printf("idempotent: lease of %s expired before the result was stored", keys[i])
+			}
+		}
+	}
+
+	for i := range results {
+		outcome := classifyBatch(results[i])
+		if saveFailed[i] {
+			// 处理完成但结果没能写入存储
+			outcome = OutcomeStoreError
+		}
+		g.observe(ctx, keys[i], o.operation, start, outcome, results[i].Err)
+	}
+	return results, execErr
+}
+
+// batchKeyError 并发执行以条目下标为 key，将其中的 *BatchError[int] 转换为以幂等键标识的 *BatchError[string]
+func batchKeyError(keys []string, err error) error {
+	var indexed *concurrent_executor.BatchError[int]
+	if !errors.As(err, &indexed) {
+		return err
+	}
+	keyed := &concurrent_executor.BatchError[string]{
+		Total:     indexed.Total,
+		Succeeded: indexed.Succeeded,
+		Failed:    indexed.Failed,
+		Panics:    indexed.Panics,
+		Timeouts:  indexed.Timeouts,
+		Canceled:  indexed.Canceled,
+	}
+	for _, f := range indexed.Failures {
+		keyed.Failures = append(keyed.Failures, &concurrent_executor.KeyError[string]{Key: keys[f.Key], Err: f.Err})
+	}
+	return keyed
+}
+
+// observeStoreError 整批因访问存储失败而返回时，为每个 key 记录存储错误
+func (g *Guard) observeStoreError(ctx context.Context, keys []string, operation string, start time.Time, err error) {
+	for _, key := range keys {
+		g.observe(ctx, key, operation, start, OutcomeStoreError, err)
+	}
+}
+
+// runRecovered 与并发执行时一样，将 panic 转换为 *concurrent_executor.PanicError，
+// 避免一个条目的 panic 让整批的认领一直停留在 IN_PROGRESS
+func runRecovered[T any](ctx context.Context, i int, run func(context.Context, int) (T, error)) (value T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &concurrent_executor.PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return run(ctx, i)
+}
+
+func classifyBatch[T any](r BatchResult[T]) Outcome {
+	if r.Replayed {
+		if errors.Is(r.Err, ErrRequestInProgress) {
+			return OutcomeInProgress
+		}
+		return OutcomeReplayed
+	}
+	if r.Err != nil {
+		return OutcomeFailed
+	}
+	return OutcomeExecuted
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+func releaseClaims(ctx context.Context, store IdempotencyStore, keys, claims []string, pending []int) {
+	entries := make([]SwapEntry, len(pending))
+	for n, i := range pending {
+		entries[n] = SwapEntry{Key: keys[i], Old: claims[i]}
+	}
+	// ctx 被取消导致的失败同样需要释放认领
+	if _, err := compareAndDeleteBatch(context.WithoutCancel(ctx), store, entries); err != nil {
+		log.Printf("idempotent: release %d batch claims failed: %v", len(entries), err)
+	}
+}
+
+// 以下函数在存储实现了 BatchStore 时使用批量操作，否则逐项调用
+
+func setNXBatch(ctx context.Context, store IdempotencyStore, keys, values []string, ttl time.Duration) ([]bool, error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.SetNXBatch(ctx, keys, values, ttl)
+	}
+	ok := make([]bool, len(keys))
+	for i, key := range keys {
+		var err error
+		if ok[i], err = store.SetNX(ctx, key, values[i], ttl); err != nil {
+			return nil, err
+		}
+	}
+	return ok, nil
+}
+
+func getBatch(ctx context.Context, store IdempotencyStore, keys []string) (map[string]string, error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.GetBatch(ctx, keys)
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		val, err := store.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = val
+	}
+	return values, nil
+}
+
+func compareAndSwapBatch(ctx context.Context, store IdempotencyStore, entries []SwapEntry, ttl time.Duration) ([]bool, error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.CompareAndSwapBatch(ctx, entries, ttl)
+	}
+	ok := make([]bool, len(entries))
+	for i, e := range entries {
+		var err error
+		if ok[i], err = store.CompareAndSwap(ctx, e.Key, e.Old, e.Value, ttl); err != nil {
+			return nil, err
+		}
+	}
+	return ok, nil
+}
+
+func compareAndDeleteBatch(ctx context.Context, store IdempotencyStore, entries []SwapEntry) ([]bool, error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.CompareAndDeleteBatch(ctx, entries)
+	}
+	ok := make([]bool, len(entries))
+	for i, e := range entries {
+		var err error
+		if ok[i], err = store.CompareAndDelete(ctx, e.Key, e.Old); err != nil {
+			return nil, err
+		}
+	}
+	return ok, nil
+}
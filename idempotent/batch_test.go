@@ -0,0 +1,233 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go-examples/concurrent_executor"
+)
+
+var _ BatchStore = (*RedisStore)(nil)
+
+// importRows 模拟导入：记录每一行被处理的次数，fail 中的行返回可重试的失败
+type importRows struct {
+	mu    sync.Mutex
+	calls map[string]int
+	fail  map[string]error
+}
+
+func newImportRows() *importRows {
+	return &importRows{calls: make(map[string]int), fail: make(map[string]error)}
+}
+
+func (r *importRows) handle(ctx context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[key]++
+	if err := r.fail[key]; err != nil {
+		return "", err
+	}
+	return strings.ToUpper(key), nil
+}
+
+func TestProcessBatch(t *testing.T) {
+	redisStore, _ := newMiniRedisStore(t)
+	stores := map[string]func() IdempotencyStore{
+		"memory": func() IdempotencyStore { return NewMemoryStore() },
+		"redis": func() IdempotencyStore {
+			_ = redisStore.client.FlushAll().Err()
+			return redisStore
+		},
+	}
+	executors := map[string][]ExecuteOption{
+		"sequential": nil,
+		"concurrent": {WithBatchExecutor(&concurrent_executor.ExecutorOptions{MaxConcurrency: 4})},
+	}
+
+	for storeName, newStore := range stores {
+		for execName, execOpts := range executors {
+			t.Run(storeName+"/"+execName, func(t *testing.T) {
+				testProcessBatch(t, newStore(), execOpts)
+			})
+		}
+	}
+}
+
+func testProcessBatch(t *testing.T, store IdempotencyStore, execOpts []ExecuteOption) {
+	ctx := context.Background()
+	guard := NewGuard(store)
+	opts := append([]ExecuteOption{WithGuard(guard), WithErrorCaching()}, execOpts...)
+	rows := newImportRows()
+
+	// 之前的批次已经处理过 row-1
+	_, err := Execute(ctx, "row-1", func(ctx context.Context) (string, error) { return "ROW-1 (earlier)", nil }, opts...)
+	assert.NoError(t, err)
+	// row-2 正在被其他实例处理
+	_, _ = store.SetNX(ctx, "row-2", record{State: StateInProgress, Owner: "other"}.encode(), time.Minute)
+
+	rows.fail["row-4"] = errors.New("downstream timeout")
+	rows.fail["row-5"] = Permanent(errors.New("invalid row"))
+
+	results, err := ProcessBatch(ctx, []string{"row-1", "row-2", "row-3", "row-4", "row-3", "row-5"}, rows.handle, opts...)
+	assert.NoError(t, err)
+	assert.Len(t, results, 5)
+
+	assert.Equal(t, BatchResult[string]{Key: "row-1", Value: "ROW-1 (earlier)", Replayed: true}, results[0])
+	assert.Equal(t, "row-2", results[1].Key)
+	assert.ErrorIs(t, results[1].Err, ErrRequestInProgress)
+	assert.Equal(t, BatchResult[string]{Key: "row-3", Value: "ROW-3"}, results[2])
+	assert.EqualError(t, results[3].Err, "downstream timeout")
+	assert.True(t, IsPermanent(results[4].Err))
+	assert.Equal(t, map[string]int{"row-3": 1, "row-4": 1, "row-5": 1}, rows.calls)
+
+	// 下一批只重新处理可重试失败的 row-4
+	delete(rows.fail, "row-4")
+	results, err = ProcessBatch(ctx, []string{"row-3", "row-4", "row-5"}, rows.handle, opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, BatchResult[string]{Key: "row-3", Value: "ROW-3", Replayed: true}, results[0])
+	assert.Equal(t, BatchResult[string]{Key: "row-4", Value: "ROW-4"}, results[1])
+	assert.True(t, results[2].Replayed)
+	assert.ErrorIs(t, results[2].Err, ErrCachedFailure)
+	assert.Equal(t, map[string]int{"row-3": 1, "row-4": 2, "row-5": 1}, rows.calls)
+
+	// 单个 Execute 也能读到批量保存的结果
+	value, err := Execute(ctx, "row-4", func(ctx context.Context) (string, error) {
+		t.Error("row-4 should not be processed again")
+		return "", nil
+	}, opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, "ROW-4", value)
+
+	snapshot := guard.Metrics().Snapshot()
+	assert.Equal(t, int64(1), snapshot.Conflicts)
+	assert.Equal(t, int64(5), snapshot.Misses)
+}
+
+func TestProcessBatch_Canceled(t *testing.T) {
+	store := NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	rows := newImportRows()
+	handle := func(ctx context.Context, key string) (string, error) {
+		if key == "row-2" {
+			cancel()
+		}
+		return rows.handle(ctx, key)
+	}
+
+	results, err := ProcessBatch(ctx, []string{"row-1", "row-2", "row-3"}, handle, WithGuard(NewGuard(store)))
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.ErrorIs(t, results[2].Err, context.Canceled)
+
+	// 未执行的条目释放了认领
+	_, err = store.Get(context.Background(), "row-3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get(context.Background(), "row-2")
+	assert.NoError(t, err)
+}
+
+func TestProcessBatch_ClaimStoreError(t *testing.T) {
+	guard := NewGuard(failingStore{NewMemoryStore()})
+	_, err := ProcessBatch(context.Background(), []string{"row-1", "row-2"}, newImportRows().handle, WithGuard(guard))
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), guard.Metrics().Snapshot().StoreErrors)
+}
+
+// failingSaveStore 认领正常，保存结果失败
+type failingSaveStore struct {
+	*MemoryStore
+}
+
+func (failingSaveStore) CompareAndSwap(context.Context, string, string, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestProcessBatch_SaveStoreError(t *testing.T) {
+	sink := NewMemoryAuditSink()
+	guard := NewGuard(failingSaveStore{NewMemoryStore()}, WithAuditSink(sink))
+	results, err := ProcessBatch(context.Background(), []string{"row-1"}, newImportRows().handle, WithGuard(guard))
+	assert.NoError(t, err)
+	assert.EqualError(t, results[0].Err, "connection refused")
+	assert.Equal(t, "ROW-1", results[0].Value)
+
+	assert.Equal(t, int64(1), guard.Metrics().Snapshot().StoreErrors)
+	events, _ := sink.History(context.Background(), "row-1")
+	assert.Equal(t, OutcomeStoreError, events[0].Outcome)
+}
+
+func TestProcessBatch_PanicReleasesClaim(t *testing.T) {
+	store := NewMemoryStore()
+	rows := newImportRows()
+	handle := func(ctx context.Context, key string) (string, error) {
+		if key == "row-2" {
+			panic("bad row")
+		}
+		return rows.handle(ctx, key)
+	}
+
+	results, err := ProcessBatch(context.Background(), []string{"row-1", "row-2", "row-3"}, handle, WithGuard(NewGuard(store)))
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	var panicErr *concurrent_executor.PanicError
+	assert.ErrorAs(t, results[1].Err, &panicErr)
+	assert.NoError(t, results[2].Err)
+
+	// panic 的条目释放了认领，下一批可以重新处理
+	_, err = store.Get(context.Background(), "row-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// flakyClaimStore 第 failAt 次 SetNX 时返回错误，模拟认领到一半连接断开
+type flakyClaimStore struct {
+	*MemoryStore
+	calls, failAt int
+}
+
+func (s *flakyClaimStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.calls++
+	if s.calls == s.failAt {
+		return false, errors.New("connection reset")
+	}
+	return s.MemoryStore.SetNX(ctx, key, value, ttl)
+}
+
+func TestProcessBatch_ClaimErrorReleasesClaimed(t *testing.T) {
+	store := &flakyClaimStore{MemoryStore: NewMemoryStore(), failAt: 3}
+	// row-0 属于其他处理者，不能被释放
+	_, _ = store.MemoryStore.SetNX(context.Background(), "row-0", record{State: StateInProgress, Owner: "other"}.encode(), time.Minute)
+
+	_, err := ProcessBatch(context.Background(), []string{"row-0", "row-1", "row-2", "row-3"}, newImportRows().handle,
+		WithGuard(NewGuard(store)))
+	assert.Error(t, err)
+
+	// 出错前认领的 row-1 被释放，下一批可以立即处理
+	_, err = store.Get(context.Background(), "row-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get(context.Background(), "row-0")
+	assert.NoError(t, err)
+}
+
+func TestProcessBatch_ReportsExecutorErrors(t *testing.T) {
+	rows := newImportRows()
+	rows.fail["row-2"] = errors.New("downstream timeout")
+	executor := &concurrent_executor.ExecutorOptions{MaxConcurrency: 2, ReportErrors: true}
+
+	results, err := ProcessBatch(context.Background(), []string{"row-1", "row-2", "row-3"}, rows.handle,
+		WithGuard(NewGuard(NewMemoryStore())), WithBatchExecutor(executor))
+	assert.Len(t, results, 3)
+	assert.EqualError(t, results[1].Err, "downstream timeout")
+
+	var batchErr *concurrent_executor.BatchError[string]
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, 3, batchErr.Total)
+		assert.Equal(t, 1, batchErr.Failed)
+		assert.Equal(t, "row-2", batchErr.Failures[0].Key)
+	}
+}
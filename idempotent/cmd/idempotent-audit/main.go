@@ -0,0 +1,62 @@
+// idempotent-audit 查询幂等键的审计记录
+//
+//	idempotent-audit -file audit.jsonl -key <幂等键> [-v]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-examples/idempotent"
+)
+
+func main() {
+	file := flag.String("file", "idempotent-audit.jsonl", "FileAuditSink 写入的审计文件")
+	key := flag.String("key", "", "要查询的幂等键")
+	verbose := flag.Bool("v", false, "输出每一条审计记录")
+	flag.Parse()
+
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "missing -key")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(os.Stdout, idempotent.NewFileAuditSink(*file), *key, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(w io.Writer, reader idempotent.AuditReader, key string, verbose bool) error {
+	events, err := reader.History(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("no audit events for key %q", key)
+	}
+
+	h := idempotent.Summarize(key, events)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "key\t%s\n", h.Key)
+	fmt.Fprintf(tw, "operation\t%s\n", h.Operation)
+	fmt.Fprintf(tw, "first seen\t%s\n", h.FirstSeen.Format(time.RFC3339Nano))
+	fmt.Fprintf(tw, "last seen\t%s\n", h.LastSeen.Format(time.RFC3339Nano))
+	fmt.Fprintf(tw, "executions\t%d\n", h.Executions)
+	fmt.Fprintf(tw, "replays\t%d\n", h.ReplayCount)
+	fmt.Fprintf(tw, "conflicts\t%d\n", h.Conflicts)
+	fmt.Fprintf(tw, "last outcome\t%s\n", h.LastOutcome)
+	if verbose {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "TIME\tOUTCOME\tLATENCY\tERROR")
+		for _, e := range events {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339Nano), e.Outcome, e.Latency, e.Error)
+		}
+	}
+	return tw.Flush()
+}
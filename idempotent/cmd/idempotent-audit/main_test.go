@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go-examples/idempotent"
+)
+
+func TestRun(t *testing.T) {
+	sink := idempotent.NewMemoryAuditSink()
+	now := time.Now()
+	ctx := context.Background()
+	_ = sink.Record(ctx, idempotent.AuditEvent{Key: "order-1", Operation: "/orders", Time: now, Outcome: idempotent.OutcomeExecuted})
+	_ = sink.Record(ctx, idempotent.AuditEvent{Key: "order-1", Time: now.Add(time.Second), Outcome: idempotent.OutcomeReplayed})
+
+	var out bytes.Buffer
+	assert.NoError(t, run(&out, sink, "order-1", true))
+	assert.Contains(t, out.String(), "/orders")
+	assert.Regexp(t, `replays\s+1`, out.String())
+	assert.Contains(t, out.String(), "replayed")
+
+	assert.Error(t, run(&out, sink, "order-2", false))
+}
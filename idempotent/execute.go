@@ -0,0 +1,189 @@
+package idempotent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-examples/concurrent_executor"
+)
+
+// ErrCachedFailure 重放被缓存的永久性失败时返回的错误
+var ErrCachedFailure = errors.New("idempotent: cached failure")
+
+// permanentError 标记为永久性的失败，重试也不会成功（如参数错误、余额不足）
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent 将 err 标记为永久性失败，开启 WithErrorCaching 时会被缓存，重复请求直接返回该错误
+// 未标记的错误（包括超时、ctx 取消）都视为可重试的失败，不会被缓存
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent err 是否被标记为永久性失败
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Codec 处理结果的序列化方式
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v any) ([]byte, error) {
+	switch val := v.(type) {
+	case string:
+		return []byte(val), nil
+	case []byte:
+		return val, nil
+	default:
+		return nil, fmt.Errorf("idempotent: string codec cannot marshal %T", v)
+	}
+}
+
+func (stringCodec) Unmarshal(data []byte, v any) error {
+	switch ptr := v.(type) {
+	case *string:
+		*ptr = string(data)
+	case *[]byte:
+		*ptr = append([]byte(nil), data...)
+	default:
+		return fmt.Errorf("idempotent: string codec cannot unmarshal into %T", v)
+	}
+	return nil
+}
+
+var (
+	// JSONCodec 默认的编码方式
+	JSONCodec Codec = jsonCodec{}
+	// StringCodec 结果原样保存，只支持 string 和 []byte，ProcessRequest 使用它保持存储格式不变
+	StringCodec Codec = stringCodec{}
+)
+
+// ExecuteOption Execute 的可选项
+type ExecuteOption func(*executeOptions)
+
+type executeOptions struct {
+	guard       *Guard
+	codec       Codec
+	cacheErrors bool
+	fingerprint string
+	ttl         time.Duration
+	operation   string
+	// batchExecutor 只用于 ProcessBatch
+	batchExecutor *concurrent_executor.ExecutorOptions
+}
+
+// WithGuard 指定使用的幂等守卫（存储、租约、等待策略），默认 DefaultGuard
+func WithGuard(g *Guard) ExecuteOption {
+	return func(o *executeOptions) {
+		o.guard = g
+	}
+}
+
+// WithCodec 指定结果的序列化方式，默认 JSONCodec
+func WithCodec(c Codec) ExecuteOption {
+	return func(o *executeOptions) {
+		o.codec = c
+	}
+}
+
+// WithErrorCaching 缓存用 Permanent 标记的失败，结果保存期内的重复请求直接返回 ErrCachedFailure
+func WithErrorCaching() ExecuteOption {
+	return func(o *executeOptions) {
+		o.cacheErrors = true
+	}
+}
+
+// WithFingerprint 记录请求内容的指纹，同一个 key 被用于指纹不同的请求时返回 ErrFingerprintMismatch
+func WithFingerprint(fingerprint string) ExecuteOption {
+	return func(o *executeOptions) {
+		o.fingerprint = fingerprint
+	}
+}
+
+// WithOperation 指定操作名，使用 Guard 中为该操作设置的 Policy
+func WithOperation(operation string) ExecuteOption {
+	return func(o *executeOptions) {
+		o.operation = operation
+	}
+}
+
+// WithTTL 本次执行结果的保存时长，覆盖 Guard 的 WithResultTTL 和 Policy.ResultTTL
+func WithTTL(ttl time.Duration) ExecuteOption {
+	return func(o *executeOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Execute 以 key 为幂等键执行 fn：同一个 key 在结果保存期内只执行一次，
+// 重复调用返回反序列化后的首次执行结果；fn 应当响应 ctx 的取消
+func Execute[T any](ctx context.Context, key string, fn func(ctx context.Context) (T, error),
+	opts ...ExecuteOption) (T, error) {
+	o := executeOptions{codec: JSONCodec}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.guard == nil {
+		o.guard = DefaultGuard()
+	}
+
+	var (
+		zero     T
+		value    T
+		executed bool
+	)
+	raw, err := o.guard.execute(ctx, key, &o, func(ctx context.Context) (string, error) {
+		v, err := fn(ctx)
+		if err != nil {
+			return "", err
+		}
+		data, err := o.codec.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		value, executed = v, true
+		return string(data), nil
+	})
+	if err != nil {
+		return zero, err
+	}
+	if executed {
+		return value, nil
+	}
+
+	// 重放之前保存的结果
+	if err := o.codec.Unmarshal([]byte(raw), &value); err != nil {
+		return zero, err
+	}
+	return value, nil
+}
@@ -0,0 +1,133 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type payment struct {
+	ID     string  `json:"id"`
+	Amount float64 `json:"amount"`
+	Paid   bool    `json:"paid"`
+}
+
+func TestExecute_TypedResultReplay(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+
+	var calls int
+	pay := func(ctx context.Context) (payment, error) {
+		calls++
+		return payment{ID: "p-1", Amount: 9.9, Paid: true}, nil
+	}
+
+	first, err := Execute(ctx, "pay-1", pay, WithGuard(guard))
+	assert.NoError(t, err)
+	second, err := Execute(ctx, "pay-1", pay, WithGuard(guard))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, first, second)
+	assert.Equal(t, payment{ID: "p-1", Amount: 9.9, Paid: true}, second)
+}
+
+func TestExecute_ErrorCaching(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+
+	var calls int
+	insufficient := func(ctx context.Context) (int, error) {
+		calls++
+		return 0, Permanent(errors.New("insufficient balance"))
+	}
+
+	// 未开启错误缓存时，永久性失败也允许重试
+	_, err := Execute(ctx, "pay-2", insufficient, WithGuard(guard))
+	assert.True(t, IsPermanent(err))
+	_, err = Execute(ctx, "pay-2", insufficient, WithGuard(guard))
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+
+	// 开启后永久性失败被缓存
+	calls = 0
+	_, err = Execute(ctx, "pay-3", insufficient, WithGuard(guard), WithErrorCaching())
+	assert.True(t, IsPermanent(err))
+	_, err = Execute(ctx, "pay-3", insufficient, WithGuard(guard), WithErrorCaching())
+	assert.ErrorIs(t, err, ErrCachedFailure)
+	assert.Contains(t, err.Error(), "insufficient balance")
+	assert.Equal(t, 1, calls)
+
+	// 可重试的失败不会被缓存
+	calls = 0
+	flaky := func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, errors.New("connection reset")
+		}
+		return 42, nil
+	}
+	_, err = Execute(ctx, "pay-4", flaky, WithGuard(guard), WithErrorCaching())
+	assert.Error(t, err)
+	v, err := Execute(ctx, "pay-4", flaky, WithGuard(guard), WithErrorCaching())
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
+
+func TestExecute_ContextCancellation(t *testing.T) {
+	guard := NewGuard(NewMemoryStore())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slow := func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(time.Second):
+			return "too late", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	start := time.Now()
+	_, err := Execute(ctx, "slow-1", slow, WithGuard(guard))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// 取消后认领被释放，可以重新执行
+	v, err := Execute(context.Background(), "slow-1", func(ctx context.Context) (string, error) {
+		return "ok", nil
+	}, WithGuard(guard))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", v)
+
+	// ProcessRequest 的处理逻辑同样受 ctx 控制
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, err = NewGuard(NewMemoryStore(), WithWork(func(ctx context.Context, requestID string) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})).Process(cancelled, "slow-2")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestExecute_StringCodec(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	guard := NewGuard(store)
+
+	_, err := Execute(ctx, "raw-1", func(ctx context.Context) ([]byte, error) {
+		return []byte("raw bytes"), nil
+	}, WithGuard(guard), WithCodec(StringCodec))
+	assert.NoError(t, err)
+
+	raw, _ := store.Get(ctx, "raw-1")
+	assert.Equal(t, "raw bytes", decodeRecord(raw).Result)
+
+	_, err = Execute(ctx, "raw-2", func(ctx context.Context) (int, error) {
+		return 1, nil
+	}, WithGuard(guard), WithCodec(StringCodec))
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package idempotent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore 以 JSON 文件持久化的存储实现，进程重启后幂等记录不丢失
+// 每次写操作都会整体重写文件（先写临时文件再 rename），只适合单进程、数据量不大的场景
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	mem  *MemoryStore
+}
+
+// NewFileStore 打开（不存在则创建）path 对应的存储文件
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path: path,
+		mem:  NewMemoryStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.mem.entries); err != nil {
+			return nil, err
+		}
+	}
+	if s.mem.entries == nil {
+		// 文件内容为 null 时 Unmarshal 会把 map 置为 nil
+		s.mem.entries = make(map[string]memoryEntry)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (string, error) {
+	return s.mem.Get(ctx, key)
+}
+
+func (s *FileStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok, err := s.mem.SetNX(ctx, key, value, ttl)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := s.persistLocked(); err != nil {
+		// 落盘失败时回滚，保证内存和文件一致
+		_ = s.mem.Delete(ctx, key)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.entryLocked(key)
+	if err := s.mem.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := s.persistLocked(); err != nil {
+		s.restoreLocked(key, prev, existed)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.entryLocked(key)
+	ok, err := s.mem.CompareAndSwap(ctx, key, old, value, ttl)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := s.persistLocked(); err != nil {
+		s.restoreLocked(key, prev, existed)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FileStore) CompareAndDelete(ctx context.Context, key, old string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.entryLocked(key)
+	ok, err := s.mem.CompareAndDelete(ctx, key, old)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := s.persistLocked(); err != nil {
+		s.restoreLocked(key, prev, existed)
+		return false, err
+	}
+	return true, nil
+}
+
+// entryLocked 读取 key 当前的原始记录（包括已过期的），用于落盘失败时回滚
+func (s *FileStore) entryLocked(key string) (memoryEntry, bool) {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	e, ok := s.mem.entries[key]
+	return e, ok
+}
+
+// restoreLocked 落盘失败时将 key 恢复为修改前的记录，保证内存和文件一致
+func (s *FileStore) restoreLocked(key string, e memoryEntry, existed bool) {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	if existed {
+		s.mem.entries[key] = e
+	} else {
+		delete(s.mem.entries, key)
+	}
+}
+
+// persistLocked 将未过期的记录写入文件
+func (s *FileStore) persistLocked() error {
+	s.mem.mu.Lock()
+	now := s.mem.now()
+	snapshot := make(map[string]memoryEntry, len(s.mem.entries))
+	for k, e := range s.mem.entries {
+		if !e.expired(now) {
+			snapshot[k] = e
+		}
+	}
+	s.mem.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
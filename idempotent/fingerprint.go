@@ -0,0 +1,133 @@
+package idempotent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+const fingerprintKeyPrefix = "fingerprint:"
+
+// FingerprintConfig 计算请求指纹时参与的内容
+// 请求方法和路径总是参与计算；JSON 请求体会先规范化（字段排序、去掉空白），
+// 因此字段顺序和格式不同的同一个请求得到同样的指纹，非 JSON 请求体按原始字节计算
+type FingerprintConfig struct {
+	// Headers 参与计算的请求头，如 Authorization、X-Tenant-Id
+	Headers []string
+	// Query 查询参数是否参与计算
+	Query bool
+	// IncludeFields 只有这些 JSON 字段参与计算，用 . 分隔嵌套字段，如 order.sku；为空表示全部字段
+	IncludeFields []string
+	// ExcludeFields 不参与计算的 JSON 字段，如每次提交都会变化的 timestamp、nonce
+	ExcludeFields []string
+	// UserID 从请求中提取用户ID，使不同用户的相同请求互不影响
+	UserID func(r *http.Request) string
+}
+
+// Fingerprint 计算请求的规范化指纹，body 为已读取的请求体
+func Fingerprint(r *http.Request, body []byte, cfg FingerprintConfig) string {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, p := range parts {
+			h.Write([]byte(p))
+			h.Write([]byte{0})
+		}
+	}
+
+	write(r.Method, r.URL.Path)
+	if cfg.Query {
+		// Encode 按参数名排序
+		write(r.URL.Query().Encode())
+	}
+	headers := make([]string, len(cfg.Headers))
+	for i, name := range cfg.Headers {
+		headers[i] = textproto.CanonicalMIMEHeaderKey(name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		write(name, strings.Join(r.Header.Values(name), ","))
+	}
+	if cfg.UserID != nil {
+		write(cfg.UserID(r))
+	}
+	h.Write(canonicalBody(body, cfg.IncludeFields, cfg.ExcludeFields))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FingerprintKey 由请求指纹得到的幂等键，用于客户端不携带幂等键的场景
+func FingerprintKey(r *http.Request, body []byte, cfg FingerprintConfig) string {
+	return fingerprintKeyPrefix + Fingerprint(r, body, cfg)
+}
+
+// canonicalBody 规范化 JSON 请求体，不是 JSON 时原样返回
+func canonicalBody(body []byte, include, exclude []string) []byte {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// 保留数字原文，避免大整数丢失精度
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+
+	if obj, ok := v.(map[string]any); ok {
+		if len(include) > 0 {
+			picked := make(map[string]any)
+			for _, path := range include {
+				pickField(obj, picked, strings.Split(path, "."))
+			}
+			obj = picked
+		}
+		for _, path := range exclude {
+			dropField(obj, strings.Split(path, "."))
+		}
+		v = obj
+	}
+
+	// encoding/json 编码 map 时按 key 排序
+	data, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// pickField 把 src 中 path 指向的字段复制到 dst
+func pickField(src, dst map[string]any, path []string) {
+	val, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = val
+		return
+	}
+	child, ok := val.(map[string]any)
+	if !ok {
+		return
+	}
+	next, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		next = make(map[string]any)
+		dst[path[0]] = next
+	}
+	pickField(child, next, path[1:])
+}
+
+// dropField 删除 obj 中 path 指向的字段
+func dropField(obj map[string]any, path []string) {
+	if len(path) == 1 {
+		delete(obj, path[0])
+		return
+	}
+	if child, ok := obj[path[0]].(map[string]any); ok {
+		dropField(child, path[1:])
+	}
+}
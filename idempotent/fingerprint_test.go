@@ -0,0 +1,118 @@
+package idempotent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFingerprintRequest(method, target, user, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if user != "" {
+		req.Header.Set("X-User-Id", user)
+	}
+	return req
+}
+
+func TestFingerprint_Canonical(t *testing.T) {
+	cfg := FingerprintConfig{ExcludeFields: []string{"timestamp", "meta.traceId"}}
+	fp := func(method, target, body string) string {
+		return Fingerprint(newFingerprintRequest(method, target, "", body), []byte(body), cfg)
+	}
+
+	base := fp(http.MethodPost, "/orders", `{"sku":"A","qty":1,"meta":{"traceId":"t1","channel":"app"}}`)
+	// 字段顺序、空白和排除的字段不影响指纹
+	assert.Equal(t, base, fp(http.MethodPost, "/orders",
+		"{ \"qty\": 1,\n \"meta\": {\"channel\":\"app\",\"traceId\":\"t2\"}, \"sku\": \"A\", \"timestamp\": 1700000000 }"))
+
+	assert.NotEqual(t, base, fp(http.MethodPost, "/orders", `{"sku":"A","qty":2,"meta":{"channel":"app"}}`))
+	assert.NotEqual(t, base, fp(http.MethodPost, "/orders", `{"sku":"A","qty":1,"meta":{"channel":"web"}}`))
+	assert.NotEqual(t, base, fp(http.MethodPut, "/orders", `{"sku":"A","qty":1,"meta":{"channel":"app"}}`))
+	assert.NotEqual(t, base, fp(http.MethodPost, "/payments", `{"sku":"A","qty":1,"meta":{"channel":"app"}}`))
+
+	// 大整数保持原文
+	assert.NotEqual(t, fp(http.MethodPost, "/orders", `{"id":9007199254740993}`),
+		fp(http.MethodPost, "/orders", `{"id":9007199254740992}`))
+
+	// 非 JSON 请求体按原始字节计算
+	assert.Equal(t, fp(http.MethodPost, "/orders", "sku=A"), fp(http.MethodPost, "/orders", "sku=A"))
+	assert.NotEqual(t, fp(http.MethodPost, "/orders", "sku=A"), fp(http.MethodPost, "/orders", "sku=B"))
+}
+
+func TestFingerprint_IncludeFields(t *testing.T) {
+	cfg := FingerprintConfig{IncludeFields: []string{"sku", "address.city"}}
+	fp := func(body string) string {
+		return Fingerprint(newFingerprintRequest(http.MethodPost, "/orders", "", body), []byte(body), cfg)
+	}
+
+	base := fp(`{"sku":"A","remark":"x","address":{"city":"SH","street":"1"}}`)
+	assert.Equal(t, base, fp(`{"sku":"A","remark":"y","address":{"city":"SH","street":"2"}}`))
+	assert.NotEqual(t, base, fp(`{"sku":"A","address":{"city":"BJ"}}`))
+	assert.NotEqual(t, base, fp(`{"sku":"B","address":{"city":"SH"}}`))
+}
+
+func TestFingerprint_HeadersQueryAndUser(t *testing.T) {
+	cfg := FingerprintConfig{
+		Headers: []string{"x-tenant-id"},
+		Query:   true,
+		UserID:  func(r *http.Request) string { return r.Header.Get("X-User-Id") },
+	}
+	fp := func(target, user, tenant string) string {
+		req := newFingerprintRequest(http.MethodPost, target, user, "")
+		req.Header.Set("X-Tenant-Id", tenant)
+		req.Header.Set("X-Request-Time", time.Now().String())
+		return Fingerprint(req, nil, cfg)
+	}
+
+	base := fp("/orders?a=1&b=2", "u1", "t1")
+	assert.Equal(t, base, fp("/orders?b=2&a=1", "u1", "t1"))
+	assert.NotEqual(t, base, fp("/orders?a=1&b=3", "u1", "t1"))
+	assert.NotEqual(t, base, fp("/orders?a=1&b=2", "u2", "t1"))
+	assert.NotEqual(t, base, fp("/orders?a=1&b=2", "u1", "t2"))
+}
+
+func TestHTTPMiddleware_DerivedKey(t *testing.T) {
+	var calls int32
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	cfg := FingerprintConfig{
+		ExcludeFields: []string{"timestamp"},
+		UserID:        func(r *http.Request) string { return r.Header.Get("X-User-Id") },
+	}
+	handler := NewHTTPMiddleware(NewGuard(store), WithDerivedKey(cfg, 5*time.Second))(newOrderHandler(&calls, nil))
+
+	submit := func(user, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newFingerprintRequest(http.MethodPost, "/orders", user, body))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, submit("u1", `{"sku":"A","timestamp":1}`).Code)
+	dup := submit("u1", `{"timestamp":2, "sku":"A"}`)
+	assert.Equal(t, http.StatusCreated, dup.Code)
+	assert.Equal(t, "true", dup.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(1), calls)
+
+	// 其他用户或其他内容的提交不受影响
+	submit("u2", `{"sku":"A","timestamp":1}`)
+	submit("u1", `{"sku":"B","timestamp":1}`)
+	assert.Equal(t, int32(3), calls)
+
+	// 超过窗口后同样的提交重新处理
+	now = now.Add(6 * time.Second)
+	assert.Empty(t, submit("u1", `{"sku":"A","timestamp":3}`).Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(4), calls)
+
+	// 显式携带幂等键时仍然使用幂等键
+	rec := doRequest(handler, http.MethodPost, "key-1", `{"sku":"A"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, int32(5), calls)
+	_, err := store.Get(context.Background(), "http::key-1")
+	assert.NoError(t, err)
+}
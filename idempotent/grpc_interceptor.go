@@ -0,0 +1,165 @@
+package idempotent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// GRPCIdempotencyKeyMetadata 客户端携带幂等键的 metadata（gRPC metadata 的 key 均为小写）
+	GRPCIdempotencyKeyMetadata = "idempotency-key"
+	// GRPCIdempotentReplayedMetadata 响应是重放之前保存的结果时，在响应 header 中设置为 true
+	GRPCIdempotentReplayedMetadata = "idempotent-replayed"
+)
+
+// NewUnaryServerInterceptor gRPC 一元拦截器，与 ProcessRequest 使用同样的存储和去重逻辑：
+//   - 首次请求正常处理，响应消息序列化（google.protobuf.Any）后按幂等键保存
+//   - 重复请求直接反序列化并返回保存的响应，不再调用 handler
+//   - 首次请求仍在处理中时返回 codes.Aborted
+//   - 同一个幂等键用于方法或请求消息不同的请求时返回 codes.FailedPrecondition
+//   - 首次请求的响应超过 Policy.MaxPayloadSize 没有保存时返回 codes.AlreadyExists，请求不会被再次处理
+//
+// handler 返回错误时不保存结果，客户端可以用同一个幂等键重试；开启 Policy.CacheFailures 时
+// 用 Permanent 标记的错误会被保存，重复请求返回同样的状态码和描述；Guard 中以完整方法名（如 /pkg.Service/Method）设置的 Policy 对该方法生效
+func NewUnaryServerInterceptor(guard *Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		key := incomingIdempotencyKey(ctx)
+		if key == "" {
+			return handler(ctx, req)
+		}
+
+		fingerprint, err := grpcFingerprint(info.FullMethod, req)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		var (
+			fresh      any
+			handled    bool
+			handlerErr error
+		)
+		data, err := Execute(ctx, key, func(ctx context.Context) ([]byte, error) {
+			fresh, handlerErr = handler(ctx, req)
+			handled = true
+			if handlerErr != nil {
+				if IsPermanent(handlerErr) {
+					// 保存状态码，重放时恢复
+					return nil, Permanent(errors.New(encodeStatus(handlerErr)))
+				}
+				return nil, handlerErr
+			}
+			msg, ok := fresh.(proto.Message)
+			if !ok {
+				return nil, fmt.Errorf("idempotent: response %T is not a proto message", fresh)
+			}
+			packed, err := anypb.New(msg)
+			if err != nil {
+				return nil, err
+			}
+			return proto.Marshal(packed)
+		}, WithGuard(guard), WithCodec(StringCodec), WithFingerprint(fingerprint),
+			WithOperation(info.FullMethod))
+
+		switch {
+		case handled:
+			if handlerErr != nil {
+				return nil, handlerErr
+			}
+			if err != nil {
+				// handler 成功但结果未能保存，照常返回本次的响应
+				log.Printf("idempotent: store response of %s failed: %v", key, err)
+			}
+			return fresh, nil
+		case err == nil:
+			return replayResponse(ctx, data)
+		case errors.Is(err, ErrRequestInProgress):
+			return nil, status.Error(codes.Aborted, err.Error())
+		case errors.Is(err, ErrFingerprintMismatch):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, ErrCachedFailure):
+			_ = grpc.SetHeader(ctx, metadata.Pairs(GRPCIdempotentReplayedMetadata, "true"))
+			return nil, decodeStatus(strings.TrimPrefix(err.Error(), ErrCachedFailure.Error()+": "))
+		case errors.Is(err, ErrPayloadTooLarge):
+			_ = grpc.SetHeader(ctx, metadata.Pairs(GRPCIdempotentReplayedMetadata, "true"))
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		case errors.Is(err, context.Canceled):
+			return nil, status.Error(codes.Canceled, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			return nil, status.Error(codes.DeadlineExceeded, err.Error())
+		default:
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+}
+
+func incomingIdempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(GRPCIdempotencyKeyMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcFingerprint 方法名和请求消息（确定性序列化）的摘要
+func grpcFingerprint(fullMethod string, req any) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(fullMethod))
+	h.Write([]byte{0})
+	if msg, ok := req.(proto.Message); ok {
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// encodeStatus 将 handler 返回的错误编码为“状态码 描述”，作为被缓存的失败保存
+func encodeStatus(err error) string {
+	st := status.New(codes.Unknown, err.Error())
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		st = se.GRPCStatus()
+	}
+	return fmt.Sprintf("%d %s", st.Code(), st.Message())
+}
+
+// decodeStatus 还原 encodeStatus 保存的错误
+func decodeStatus(text string) error {
+	codeText, msg, _ := strings.Cut(text, " ")
+	code, err := strconv.ParseUint(codeText, 10, 32)
+	if err != nil {
+		return status.Error(codes.Unknown, text)
+	}
+	return status.Error(codes.Code(code), msg)
+}
+
+func replayResponse(ctx context.Context, data []byte) (any, error) {
+	var packed anypb.Any
+	if err := proto.Unmarshal(data, &packed); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	msg, err := packed.UnmarshalNew()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(GRPCIdempotentReplayedMetadata, "true"))
+	return msg, nil
+}
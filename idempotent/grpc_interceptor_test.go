@@ -0,0 +1,171 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// countingHealthServer 每次调用返回不同的状态，用于区分新结果和重放的结果
+type countingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	calls int32
+	fail  atomic.Bool
+}
+
+func (s *countingHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if s.fail.Load() {
+		atomic.AddInt32(&s.calls, 1)
+		return nil, status.Error(codes.Internal, "boom")
+	}
+	if atomic.AddInt32(&s.calls, 1) == 1 {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+}
+
+func newBufconnClient(t *testing.T, srv healthpb.HealthServer, guard *Guard) healthpb.HealthClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(NewUnaryServerInterceptor(guard)))
+	healthpb.RegisterHealthServer(server, srv)
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+	return healthpb.NewHealthClient(conn)
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), GRPCIdempotencyKeyMetadata, key)
+}
+
+func TestUnaryServerInterceptor_Replay(t *testing.T) {
+	srv := &countingHealthServer{}
+	client := newBufconnClient(t, srv, NewGuard(NewMemoryStore()))
+	req := &healthpb.HealthCheckRequest{Service: "orders"}
+
+	var header metadata.MD
+	first, err := client.Check(withKey("key-1"), req, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, first.Status)
+	assert.Empty(t, header.Get(GRPCIdempotentReplayedMetadata))
+
+	second, err := client.Check(withKey("key-1"), req, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, second.Status)
+	assert.Equal(t, []string{"true"}, header.Get(GRPCIdempotentReplayedMetadata))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// 同一个 key 用于不同的请求
+	_, err = client.Check(withKey("key-1"), &healthpb.HealthCheckRequest{Service: "payments"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// 不带 key 的请求不做去重
+	third, err := client.Check(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, third.Status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+}
+
+func TestUnaryServerInterceptor_ErrorNotCached(t *testing.T) {
+	srv := &countingHealthServer{}
+	srv.fail.Store(true)
+	client := newBufconnClient(t, srv, NewGuard(NewMemoryStore()))
+	req := &healthpb.HealthCheckRequest{Service: "orders"}
+
+	_, err := client.Check(withKey("key-1"), req)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// handler 出错后同一个 key 可以重试
+	srv.fail.Store(false)
+	resp, err := client.Check(withKey("key-1"), req)
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+}
+
+func TestUnaryServerInterceptor_InProgress(t *testing.T) {
+	store := NewMemoryStore()
+	guard := NewGuard(store)
+	client := newBufconnClient(t, &countingHealthServer{}, guard)
+	req := &healthpb.HealthCheckRequest{Service: "orders"}
+
+	// 模拟另一个实例正在处理同一个 key
+	fingerprint, err := grpcFingerprint(healthpb.Health_Check_FullMethodName, req)
+	assert.NoError(t, err)
+	ok, err := store.SetNX(context.Background(), "key-1",
+		record{State: StateInProgress, Owner: "other", Fingerprint: fingerprint}.encode(), defaultLease)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = client.Check(withKey("key-1"), req)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
+
+// rejectingHealthServer 总是返回用 Permanent 标记的参数错误
+type rejectingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	calls int32
+}
+
+func (s *rejectingHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return nil, Permanent(status.Error(codes.InvalidArgument, "unknown service"))
+}
+
+func TestUnaryServerInterceptor_CachedFailure(t *testing.T) {
+	srv := &rejectingHealthServer{}
+	guard := NewGuard(NewMemoryStore(), WithPolicy(healthpb.Health_Check_FullMethodName, Policy{CacheFailures: true}))
+	client := newBufconnClient(t, srv, guard)
+	req := &healthpb.HealthCheckRequest{Service: "orders"}
+
+	_, err := client.Check(withKey("key-1"), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 重放保存的失败，状态码和描述与首次相同
+	var header metadata.MD
+	_, err = client.Check(withKey("key-1"), req, grpc.Header(&header))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "unknown service", status.Convert(err).Message())
+	assert.Equal(t, []string{"true"}, header.Get(GRPCIdempotentReplayedMetadata))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+}
+
+func TestUnaryServerInterceptor_PayloadTooLarge(t *testing.T) {
+	srv := &countingHealthServer{}
+	guard := NewGuard(NewMemoryStore(), WithPolicy(healthpb.Health_Check_FullMethodName, Policy{MaxPayloadSize: 1}))
+	client := newBufconnClient(t, srv, guard)
+	req := &healthpb.HealthCheckRequest{Service: "orders"}
+
+	_, err := client.Check(withKey("key-1"), req)
+	assert.NoError(t, err)
+
+	_, err = client.Check(withKey("key-1"), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+}
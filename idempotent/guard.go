@@ -0,0 +1,341 @@
+package idempotent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRequestInProgress 相同 requestID 的请求正在处理中
+	ErrRequestInProgress = errors.New("idempotent: request in progress")
+	// ErrFingerprintMismatch 相同 requestID 被用于内容不同的请求
+	ErrFingerprintMismatch = errors.New("idempotent: key reused with a different request")
+)
+
+const (
+	// StateInProgress 请求已被某个处理者认领，正在处理
+	StateInProgress = "IN_PROGRESS"
+	// StateDone 请求已处理完成，记录中保存了处理结果
+	StateDone = "DONE"
+
+	defaultLease        = 10 * time.Second
+	defaultPollInterval = 50 * time.Millisecond
+)
+
+// record 存储中保存的幂等记录
+type record struct {
+	State  string `json:"state"`
+	Owner  string `json:"owner,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"` // 被缓存的永久性失败
+	// Oversized 处理结果超过 MaxPayloadSize，没有保存
+	Oversized bool `json:"oversized,omitempty"`
+	// Fingerprint 首次请求的内容指纹，同一个 key 携带不同内容时拒绝处理
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+func (r record) encode() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+// replay 已完成的记录中保存的处理结果
+func (r record) replay() (string, error) {
+	if r.Oversized {
+		return "", ErrPayloadTooLarge
+	}
+	if r.Error != "" {
+		return "", fmt.Errorf("%w: %s", ErrCachedFailure, r.Error)
+	}
+	return r.Result, nil
+}
+
+// decodeRecord 解析幂等记录；旧版本直接保存结果字符串，按已完成处理
+func decodeRecord(raw string) record {
+	var r record
+	if err := json.Unmarshal([]byte(raw), &r); err != nil || r.State == "" {
+		return record{State: StateDone, Result: raw}
+	}
+	return r
+}
+
+// Guard 幂等守卫，请求的处理过程是一个状态机：
+//
+//	(无记录) --SetNX 认领--> IN_PROGRESS --处理成功--> DONE
+//	                            |--永久性失败（开启错误缓存时）--> DONE（保存错误）
+//	                            |--可重试的失败--> (删除记录，允许重试)
+//	                            |--租约到期--> (记录过期，可被重新认领)
+//
+// 认领是原子操作，并发的重复请求只有一个能执行处理逻辑，其余的等待结果或立即返回 ErrRequestInProgress
+type Guard struct {
+	store        IdempotencyStore
+	ttl          time.Duration
+	lease        time.Duration
+	wait         time.Duration
+	pollInterval time.Duration
+	policies     map[string]Policy
+	audit        AuditSink
+	metrics      Metrics
+	work         func(ctx context.Context, requestID string) (string, error)
+}
+
+// GuardOption Guard 的可选项
+type GuardOption func(*Guard)
+
+// WithResultTTL 处理结果的保存时间，默认 ExpirationTime
+func WithResultTTL(ttl time.Duration) GuardOption {
+	return func(g *Guard) {
+		g.ttl = ttl
+	}
+}
+
+// WithLease 认领后的租约时长，默认 10 秒
+// 处理者崩溃时 IN_PROGRESS 记录在租约到期后自动失效，租约应大于处理逻辑的最长耗时
+func WithLease(lease time.Duration) GuardOption {
+	return func(g *Guard) {
+		g.lease = lease
+	}
+}
+
+// WithWait 重复请求遇到 IN_PROGRESS 时最多等待多久以获取处理结果，默认 0 即立即返回 ErrRequestInProgress
+func WithWait(wait, pollInterval time.Duration) GuardOption {
+	return func(g *Guard) {
+		g.wait = wait
+		if pollInterval > 0 {
+			g.pollInterval = pollInterval
+		}
+	}
+}
+
+// WithWork 替换 ProcessRequest 实际的处理逻辑，默认 WorkContext
+func WithWork(work func(ctx context.Context, requestID string) (string, error)) GuardOption {
+	return func(g *Guard) {
+		g.work = work
+	}
+}
+
+// WithAuditSink 每次调用结束后向 sink 写入一条审计记录，写入失败只记录日志
+func WithAuditSink(sink AuditSink) GuardOption {
+	return func(g *Guard) {
+		g.audit = sink
+	}
+}
+
+// NewGuard 使用指定的存储后端创建幂等守卫
+func NewGuard(store IdempotencyStore, opts ...GuardOption) *Guard {
+	g := &Guard{
+		store:        store,
+		ttl:          ExpirationTime,
+		lease:        defaultLease,
+		pollInterval: defaultPollInterval,
+		work:         WorkContext,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// ProcessRequest 同一个 requestID 在结果保存期内只会被处理一次，重复请求直接返回之前的结果；
+// 处理和等待结果最多 WorkTimeout，需要其他时限时使用 Process
+func (g *Guard) ProcessRequest(requestID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), WorkTimeout)
+	defer cancel()
+	return g.Process(ctx, requestID)
+}
+
+// Process 同 ProcessRequest，处理逻辑和等待处理结果时都会响应 ctx 的取消
+func (g *Guard) Process(ctx context.Context, requestID string) (string, error) {
+	return Execute(ctx, requestID, func(ctx context.Context) (string, error) {
+		return g.work(ctx, requestID)
+	}, WithGuard(g), WithCodec(StringCodec))
+}
+
+// Metrics 返回守卫的计数器
+func (g *Guard) Metrics() *Metrics {
+	return &g.metrics
+}
+
+// execute 幂等地执行 fn，返回本次或之前保存的结果（已编码），并记录指标和审计记录
+func (g *Guard) execute(ctx context.Context, key string, o *executeOptions,
+	fn func(context.Context) (string, error)) (string, error) {
+	start := time.Now()
+	var (
+		executed bool
+		fnErr    error
+	)
+	result, err := g.claimAndRun(ctx, key, o, func(ctx context.Context) (string, error) {
+		executed = true
+		result, err := fn(ctx)
+		fnErr = err
+		return result, err
+	})
+
+	g.observe(ctx, key, o.operation, start, classify(executed, err, fnErr), err)
+	return result, err
+}
+
+// observe 记录指标和审计记录
+func (g *Guard) observe(ctx context.Context, key, operation string, start time.Time, outcome Outcome, err error) {
+	g.metrics.observe(outcome)
+	if g.audit == nil {
+		return
+	}
+	event := AuditEvent{
+		Key:       key,
+		Operation: operation,
+		Time:      start,
+		Outcome:   outcome,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	// 审计记录不能因为请求的 ctx 被取消而丢失
+	if auditErr := g.audit.Record(context.WithoutCancel(ctx), event); auditErr != nil {
+		log.Printf("idempotent: record audit event of %s failed: %v", key, auditErr)
+	}
+}
+
+// classify 根据调用结果得到审计记录中的 Outcome
+func classify(executed bool, err, fnErr error) Outcome {
+	switch {
+	case executed && err == nil:
+		return OutcomeExecuted
+	case executed && err == fnErr:
+		return OutcomeFailed
+	case executed:
+		// 处理成功但结果没能写入存储
+		return OutcomeStoreError
+	case err == nil, errors.Is(err, ErrCachedFailure), errors.Is(err, ErrPayloadTooLarge):
+		return OutcomeReplayed
+	case errors.Is(err, ErrRequestInProgress):
+		return OutcomeInProgress
+	case errors.Is(err, ErrFingerprintMismatch):
+		return OutcomeMismatch
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeCanceled
+	default:
+		return OutcomeStoreError
+	}
+}
+
+// claimAndRun 认领 key 并执行 fn，已被认领时返回之前保存的结果
+func (g *Guard) claimAndRun(ctx context.Context, key string, o *executeOptions,
+	fn func(context.Context) (string, error)) (string, error) {
+	p := g.policy(o.operation)
+	var deadline time.Time
+	if g.wait > 0 {
+		deadline = time.Now().Add(g.wait)
+	}
+
+	for {
+		// 原子地认领请求
+		claim := record{State: StateInProgress, Owner: uuid.New().String(), Fingerprint: o.fingerprint}.encode()
+		claimed, err := g.store.SetNX(ctx, key, claim, p.LeaseDuration)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return g.run(ctx, key, claim, p, o, fn)
+		}
+
+		// 已被认领，检查处理状态
+		raw, err := g.store.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// 记录刚好过期或被释放，重新认领
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		existing := decodeRecord(raw)
+		if o.fingerprint != "" && existing.Fingerprint != "" && o.fingerprint != existing.Fingerprint {
+			return "", ErrFingerprintMismatch
+		}
+		if existing.State == StateDone {
+			// 如果已处理完成，直接返回之前的处理结果
+			return existing.replay()
+		}
+
+		if deadline.IsZero() || time.Now().After(deadline) {
+			return "", ErrRequestInProgress
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(g.pollInterval):
+		}
+	}
+}
+
+// run 执行处理逻辑并保存结果，claim 为认领时写入的记录
+func (g *Guard) run(ctx context.Context, key, claim string, p Policy, o *executeOptions,
+	fn func(context.Context) (string, error)) (string, error) {
+	// 处理请求逻辑...
+	result, err := fn(ctx)
+	done, release := finish(key, result, err, p, o)
+	if release {
+		// 可重试的失败释放认领，允许客户端重试
+		if _, releaseErr := g.store.CompareAndDelete(ctx, key, claim); releaseErr != nil {
+			log.Printf("idempotent: release %s failed: %v", key, releaseErr)
+		}
+		return "", err
+	}
+
+	// 只有仍持有租约时才能写入结果，避免覆盖租约过期后其他处理者的记录
+	stored, storeErr := g.store.CompareAndSwap(ctx, key, claim, done.encode(), resultTTL(p, o))
+	if storeErr != nil {
+		// 处理存储状态错误
+		return "", storeErr
+	}
+	if !stored {
+		log.Printf("idempotent: lease of %s expired before the result was stored", key)
+	}
+	return result, err
+}
+
+// finish 根据处理结果得到要保存的记录，release 为 true 表示是可重试的失败，应当释放认领而不保存
+func finish(key, result string, err error, p Policy, o *executeOptions) (done record, release bool) {
+	if err != nil {
+		if !(o.cacheErrors || p.CacheFailures) || !IsPermanent(err) {
+			return record{}, true
+		}
+		// 永久性失败和成功一样保存下来，重复请求直接返回同样的错误
+		return record{State: StateDone, Error: err.Error(), Fingerprint: o.fingerprint}, false
+	}
+	if p.MaxPayloadSize > 0 && len(result) > p.MaxPayloadSize {
+		// 结果不保存，但仍然占住 key，避免重复请求再次执行
+		log.Printf("idempotent: result of %s is %d bytes, exceeds %d", key, len(result), p.MaxPayloadSize)
+		return record{State: StateDone, Oversized: true, Fingerprint: o.fingerprint}, false
+	}
+	return record{State: StateDone, Result: result, Fingerprint: o.fingerprint}, false
+}
+
+// resultTTL 处理结果的保存时长，WithTTL 优先于策略
+func resultTTL(p Policy, o *executeOptions) time.Duration {
+	if o.ttl > 0 {
+		return o.ttl
+	}
+	return p.ResultTTL
+}
+
+var (
+	defaultGuardOnce sync.Once
+	defaultGuard     *Guard
+)
+
+// DefaultGuard 基于 SharedRedis 的幂等守卫，整个进程共享一个
+func DefaultGuard() *Guard {
+	defaultGuardOnce.Do(func() {
+		defaultGuard = NewGuard(NewRedisStore(SharedRedis()))
+	})
+	return defaultGuard
+}
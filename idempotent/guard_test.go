@@ -0,0 +1,170 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowWork 统计执行次数，模拟耗时的第三方调用
+func slowWork(executed *int32, d time.Duration) func(context.Context, string) (string, error) {
+	return func(ctx context.Context, requestID string) (string, error) {
+		atomic.AddInt32(executed, 1)
+		time.Sleep(d)
+		return "result of " + requestID, nil
+	}
+}
+
+func runConcurrently(n int, fn func() (string, error)) ([]string, []error) {
+	results := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = fn()
+		}(i)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func TestGuard_ConcurrentDuplicatesFailFast(t *testing.T) {
+	var executed int32
+	guard := NewGuard(NewMemoryStore(), WithWork(slowWork(&executed, 100*time.Millisecond)))
+
+	results, errs := runConcurrently(50, func() (string, error) {
+		return guard.ProcessRequest("order-1")
+	})
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+	var succeeded int
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+			assert.Equal(t, "result of order-1", results[i])
+		} else {
+			assert.ErrorIs(t, err, ErrRequestInProgress)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// 处理完成后的重复请求直接拿到结果
+	result, err := guard.ProcessRequest("order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "result of order-1", result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+}
+
+func TestGuard_ConcurrentDuplicatesWait(t *testing.T) {
+	var executed int32
+	guard := NewGuard(NewMemoryStore(),
+		WithWork(slowWork(&executed, 100*time.Millisecond)),
+		WithWait(time.Second, 10*time.Millisecond))
+
+	results, errs := runConcurrently(50, func() (string, error) {
+		return guard.ProcessRequest("order-2")
+	})
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+	for i := range errs {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, "result of order-2", results[i])
+	}
+}
+
+func TestGuard_WaitTimeoutAndCancel(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	// 另一个处理者持有租约
+	_, _ = store.SetNX(ctx, "order-3", record{State: StateInProgress, Owner: "other"}.encode(), time.Minute)
+
+	guard := NewGuard(store, WithWait(50*time.Millisecond, 10*time.Millisecond))
+	_, err := guard.ProcessRequest("order-3")
+	assert.ErrorIs(t, err, ErrRequestInProgress)
+
+	guard = NewGuard(store, WithWait(time.Minute, 10*time.Millisecond))
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = guard.Process(cancelCtx, "order-3")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGuard_StaleLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	// 模拟处理者认领后崩溃，IN_PROGRESS 记录一直留在存储中
+	_, _ = store.SetNX(ctx, "order-4", record{State: StateInProgress, Owner: "crashed"}.encode(), time.Second)
+
+	var executed int32
+	guard := NewGuard(store, WithWork(slowWork(&executed, 0)))
+	_, err := guard.ProcessRequest("order-4")
+	assert.ErrorIs(t, err, ErrRequestInProgress)
+
+	// 租约到期后可以被重新认领
+	now = now.Add(time.Second)
+	result, err := guard.ProcessRequest("order-4")
+	assert.NoError(t, err)
+	assert.Equal(t, "result of order-4", result)
+	assert.Equal(t, int32(1), executed)
+}
+
+func TestGuard_FailureReleasesClaim(t *testing.T) {
+	var attempts int32
+	guard := NewGuard(NewMemoryStore(), WithWork(func(ctx context.Context, requestID string) (string, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return "", errors.New("timeout")
+		}
+		return "success result", nil
+	}))
+
+	_, err := guard.ProcessRequest("order-5")
+	assert.Error(t, err)
+
+	result, err := guard.ProcessRequest("order-5")
+	assert.NoError(t, err)
+	assert.Equal(t, "success result", result)
+	assert.Equal(t, int32(2), attempts)
+}
+
+func TestGuard_LostLeaseDoesNotOverwrite(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	guard := NewGuard(store, WithWork(func(ctx context.Context, requestID string) (string, error) {
+		// 处理期间租约过期，记录被其他处理者重新认领
+		_ = store.Delete(ctx, requestID)
+		_, _ = store.SetNX(ctx, requestID, record{State: StateInProgress, Owner: "next"}.encode(), time.Minute)
+		return "late result", nil
+	}))
+
+	result, err := guard.ProcessRequest("order-6")
+	assert.NoError(t, err)
+	assert.Equal(t, "late result", result)
+
+	raw, err := store.Get(ctx, "order-6")
+	assert.NoError(t, err)
+	assert.Equal(t, "next", decodeRecord(raw).Owner)
+}
+
+func TestGuard_ProcessRequestTimeout(t *testing.T) {
+	// 下游一直不返回时，ProcessRequest 最多等待 WorkTimeout
+	guard := NewGuard(NewMemoryStore(), WithWork(func(ctx context.Context, requestID string) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+
+	start := time.Now()
+	_, err := guard.ProcessRequest("order-7")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), WorkTimeout+time.Second)
+}
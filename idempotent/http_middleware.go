@@ -0,0 +1,315 @@
+package idempotent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader 客户端携带幂等键（GenerateToken 生成的 token）的请求头
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader 响应是重放之前保存的结果时，设置为 true
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// httpKeyPrefix 客户端携带的幂等键在存储中的前缀，避免客户端用 token:、fingerprint: 等键读到其他用途的记录
+	httpKeyPrefix = "http:"
+
+	// DefaultMaxBodyBytes 中间件读取请求体的默认上限
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+var (
+	// errServerResponse 处理结果为 5xx，视为可重试的失败，不保存响应
+	errServerResponse = errors.New("idempotent: server error response")
+	// errInvalidStoredResponse 保存的记录不是合法的 HTTP 响应，不能重放
+	errInvalidStoredResponse = errors.New("idempotent: invalid stored response")
+)
+
+// storedResponse 保存下来用于重放的 HTTP 响应
+type storedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// UnmarshalJSON 拒绝状态码不在 100~599 的记录，直接写出这样的状态码会导致 net/http panic
+func (s *storedResponse) UnmarshalJSON(data []byte) error {
+	type plain storedResponse
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if p.StatusCode < 100 || p.StatusCode > 599 {
+		return fmt.Errorf("%w: status code %d", errInvalidStoredResponse, p.StatusCode)
+	}
+	*s = storedResponse(p)
+	return nil
+}
+
+// responseRecorder 缓存处理函数写出的响应，保存后再统一写给客户端
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header)}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *responseRecorder) response() storedResponse {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return storedResponse{StatusCode: status, Header: r.header.Clone(), Body: r.body.Bytes()}
+}
+
+// HTTPOption HTTP 中间件的可选项
+type HTTPOption func(*httpOptions)
+
+type httpOptions struct {
+	header     string
+	methods    map[string]bool
+	requireKey bool
+	derive     *FingerprintConfig
+	window     time.Duration
+	operation  func(r *http.Request) string
+	scope      func(r *http.Request) string
+	maxBody    int64
+}
+
+// WithKeyHeader 读取幂等键的请求头，默认 Idempotency-Key
+func WithKeyHeader(header string) HTTPOption {
+	return func(o *httpOptions) {
+		o.header = header
+	}
+}
+
+// WithMethods 需要做幂等控制的请求方法，默认 POST、PUT、PATCH、DELETE
+func WithMethods(methods ...string) HTTPOption {
+	return func(o *httpOptions) {
+		o.methods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// WithRequiredKey 缺少幂等键的请求直接返回 400，默认放行
+func WithRequiredKey() HTTPOption {
+	return func(o *httpOptions) {
+		o.requireKey = true
+	}
+}
+
+// WithDerivedKey 请求没有携带幂等键时，由 cfg 计算的请求指纹（FingerprintKey）作为幂等键，
+// window 内指纹相同的重复提交直接重放首次的响应；window 为 0 时使用 Guard 的结果保存时长
+// 开启后 WithRequiredKey 不再生效
+func WithDerivedKey(cfg FingerprintConfig, window time.Duration) HTTPOption {
+	return func(o *httpOptions) {
+		o.derive = &cfg
+		o.window = window
+	}
+}
+
+// WithOperationFunc 从请求中得到操作名以选择 Guard 中的 Policy，默认使用请求路径
+// 路径中带有参数（如 /orders/123）时应返回路由模板
+func WithOperationFunc(operation func(r *http.Request) string) HTTPOption {
+	return func(o *httpOptions) {
+		o.operation = operation
+	}
+}
+
+// WithKeyScope 按调用方隔离客户端携带的幂等键，scope 通常返回认证后的用户或应用 ID，
+// 不同调用方使用同一个幂等键互不影响，也不能重放其他调用方保存的响应；默认所有调用方共用同一个空间
+// 由请求指纹得到的幂等键（WithDerivedKey）不受影响，通过 FingerprintConfig.UserID 区分调用方
+func WithKeyScope(scope func(r *http.Request) string) HTTPOption {
+	return func(o *httpOptions) {
+		o.scope = scope
+	}
+}
+
+// WithMaxBodyBytes 请求体的大小上限，超过时返回 413；请求体会被完整读入内存计算指纹，默认 DefaultMaxBodyBytes
+func WithMaxBodyBytes(n int64) HTTPOption {
+	return func(o *httpOptions) {
+		o.maxBody = n
+	}
+}
+
+// NewHTTPMiddleware net/http 中间件：
+//   - 首次请求正常处理，非 5xx 的响应（状态码、响应头、响应体）按幂等键保存
+//   - 重复请求直接重放保存的响应，并带上 Idempotent-Replayed: true
+//   - 首次请求仍在处理中时返回 409，并带上 Retry-After
+//   - 同一个幂等键用于请求体不同的请求时返回 422
+//   - 首次请求的响应超过 Policy.MaxPayloadSize 没有保存时返回 410，请求不会被再次处理
+//   - 请求体超过 WithMaxBodyBytes 时返回 413
+func NewHTTPMiddleware(guard *Guard, opts ...HTTPOption) func(http.Handler) http.Handler {
+	o := httpOptions{
+		header: IdempotencyKeyHeader,
+		methods: map[string]bool{
+			http.MethodPost:   true,
+			http.MethodPut:    true,
+			http.MethodPatch:  true,
+			http.MethodDelete: true,
+		},
+		operation: func(r *http.Request) string {
+			return r.URL.Path
+		},
+		maxBody: DefaultMaxBodyBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !o.methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(o.header)
+			if key == "" && o.derive == nil {
+				if o.requireKey {
+					http.Error(w, "missing "+o.header+" header", http.StatusBadRequest)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, o.maxBody))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			execOpts := []ExecuteOption{WithGuard(guard), WithOperation(o.operation(r))}
+			if key == "" {
+				fingerprint := Fingerprint(r, body, *o.derive)
+				key = fingerprintKeyPrefix + fingerprint
+				execOpts = append(execOpts, WithFingerprint(fingerprint), WithTTL(o.window))
+			} else {
+				key = o.storeKey(r, key)
+				execOpts = append(execOpts, WithFingerprint(requestFingerprint(r, body)))
+			}
+
+			var (
+				fresh   storedResponse
+				handled bool
+			)
+			resp, err := Execute(r.Context(), key, func(ctx context.Context) (storedResponse, error) {
+				recorder := newResponseRecorder()
+				next.ServeHTTP(recorder, r.WithContext(ctx))
+				fresh, handled = recorder.response(), true
+				if fresh.StatusCode >= http.StatusInternalServerError {
+					return fresh, errServerResponse
+				}
+				return fresh, nil
+			}, execOpts...)
+
+			switch {
+			case err == nil:
+				writeStoredResponse(w, resp, !handled)
+			case handled:
+				// 处理函数已执行但结果未保存（5xx 或存储出错），照常返回本次的响应
+				if !errors.Is(err, errServerResponse) {
+					log.Printf("idempotent: store response of %s failed: %v", key, err)
+				}
+				writeStoredResponse(w, fresh, false)
+			case errors.Is(err, ErrRequestInProgress):
+				// Retry-After 告诉客户端稍后重试可以拿到结果，与 token 已被使用等不可重试的 409 区分
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "a request with the same idempotency key is in progress", http.StatusConflict)
+			case errors.Is(err, ErrFingerprintMismatch):
+				http.Error(w, "idempotency key reused with a different request", http.StatusUnprocessableEntity)
+			case errors.Is(err, ErrPayloadTooLarge):
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				http.Error(w, "request already processed, its response was too large to be replayed", http.StatusGone)
+			case errors.Is(err, errInvalidStoredResponse):
+				log.Printf("idempotent: replay %s failed: %v", key, err)
+				http.Error(w, "stored response cannot be replayed", http.StatusInternalServerError)
+			case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			default:
+				log.Printf("idempotent: check %s failed: %v", key, err)
+				http.Error(w, "idempotency store unavailable", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// NewRestMiddleware go-zero rest 中间件，返回值可以直接传给 rest.Server.Use，行为同 NewHTTPMiddleware
+func NewRestMiddleware(guard *Guard, opts ...HTTPOption) func(next http.HandlerFunc) http.HandlerFunc {
+	middleware := NewHTTPMiddleware(guard, opts...)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware(next).ServeHTTP
+	}
+}
+
+// storeKey 客户端携带的幂等键在存储中的 key：http:{scope}:{key}
+// scope 经过转义，不会包含分隔符，客户端无法通过构造幂等键进入其他调用方的空间
+func (o *httpOptions) storeKey(r *http.Request, key string) string {
+	scope := ""
+	if o.scope != nil {
+		scope = url.QueryEscape(o.scope(r))
+	}
+	return httpKeyPrefix + scope + ":" + key
+}
+
+// requestFingerprint 请求方法、路径和请求体的摘要
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeStoredResponse(w http.ResponseWriter, resp storedResponse, replayed bool) {
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	if replayed {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(resp.Body)
+}
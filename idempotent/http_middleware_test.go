@@ -0,0 +1,213 @@
+package idempotent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newOrderHandler(calls *int32, release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		if release != nil {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Order-Seq", string(rune('0'+n)))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"orderId":"o-1"}`))
+	}
+}
+
+func doRequest(handler http.Handler, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPMiddleware_Replay(t *testing.T) {
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()))(newOrderHandler(&calls, nil))
+
+	first := doRequest(handler, http.MethodPost, "key-1", `{"sku":"A"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+	second := doRequest(handler, http.MethodPost, "key-1", `{"sku":"A"}`)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, "1", second.Header().Get("X-Order-Seq"))
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, int32(1), calls)
+
+	// 同一个 key 用于不同的请求体
+	mismatch := doRequest(handler, http.MethodPost, "key-1", `{"sku":"B"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestHTTPMiddleware_InFlightConflict(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()))(newOrderHandler(&calls, release))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- doRequest(handler, http.MethodPost, "key-2", `{"sku":"A"}`)
+	}()
+	// 等待首个请求进入处理函数
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conflict := doRequest(handler, http.MethodPost, "key-2", `{"sku":"A"}`)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+	assert.Equal(t, "1", conflict.Header().Get("Retry-After"))
+
+	// 处理中的请求同样校验请求体
+	mismatch := doRequest(handler, http.MethodPost, "key-2", `{"sku":"B"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestHTTPMiddleware_ServerErrorNotCached(t *testing.T) {
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "downstream timeout", http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	assert.Equal(t, http.StatusBadGateway, doRequest(handler, http.MethodPost, "key-3", "").Code)
+	assert.Equal(t, http.StatusCreated, doRequest(handler, http.MethodPost, "key-3", "").Code)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestHTTPMiddleware_PassThrough(t *testing.T) {
+	var calls int32
+	guard := NewGuard(NewMemoryStore())
+	handler := NewHTTPMiddleware(guard)(newOrderHandler(&calls, nil))
+
+	// GET 和没有幂等键的请求不做控制
+	doRequest(handler, http.MethodGet, "key-4", "")
+	doRequest(handler, http.MethodGet, "key-4", "")
+	doRequest(handler, http.MethodPost, "", "")
+	assert.Equal(t, int32(3), calls)
+
+	strict := NewHTTPMiddleware(guard, WithRequiredKey())(newOrderHandler(&calls, nil))
+	assert.Equal(t, http.StatusBadRequest, doRequest(strict, http.MethodPost, "", "").Code)
+	assert.Equal(t, int32(3), calls)
+}
+
+func TestRestMiddleware(t *testing.T) {
+	var calls int32
+	middleware := NewRestMiddleware(NewGuard(NewMemoryStore()), WithKeyHeader("X-Request-Token"))
+	handler := middleware(newOrderHandler(&calls, nil))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`))
+		req.Header.Set("X-Request-Token", "token-1")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestHTTPMiddleware_PayloadTooLarge(t *testing.T) {
+	var calls int32
+	guard := NewGuard(NewMemoryStore(), WithPolicy("/orders", Policy{MaxPayloadSize: 8}))
+	handler := NewHTTPMiddleware(guard)(newOrderHandler(&calls, nil))
+
+	first := doRequest(handler, http.MethodPost, "key-1", `{}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	// 响应没有保存，但请求不会被再次处理，也不是存储故障
+	second := doRequest(handler, http.MethodPost, "key-1", `{}`)
+	assert.Equal(t, http.StatusGone, second.Code)
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, int64(0), guard.Metrics().Snapshot().StoreErrors)
+}
+
+func TestHTTPMiddleware_KeyNamespace(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	tokens := NewTokenService(store)
+	token, _, err := tokens.Issue(ctx, "u1", "/orders")
+	assert.NoError(t, err)
+
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(store))(newOrderHandler(&calls, nil))
+
+	// 客户端携带的幂等键读不到其他用途的记录
+	rec := doRequest(handler, http.MethodPost, tokenKeyPrefix+token, `{}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(1), calls)
+	assert.NoError(t, tokens.Consume(ctx, token, "u1", "/orders"))
+}
+
+func TestHTTPMiddleware_KeyScope(t *testing.T) {
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()), WithKeyScope(func(r *http.Request) string {
+		return r.Header.Get("X-User-Id")
+	}))(newOrderHandler(&calls, nil))
+
+	submit := func(user, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`))
+		req.Header.Set("X-User-Id", user)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Empty(t, submit("u1", "key-1").Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, "true", submit("u1", "key-1").Header().Get(IdempotentReplayedHeader))
+	// 其他调用方使用同一个幂等键不会重放 u1 的响应
+	assert.Empty(t, submit("u2", "key-1").Header().Get(IdempotentReplayedHeader))
+	// 调用方标识经过转义，幂等键中的分隔符拼不出其他调用方的 key
+	assert.Empty(t, submit("u1:key", "1").Header().Get(IdempotentReplayedHeader))
+	assert.Empty(t, submit("u1", "key:1").Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(4), calls)
+}
+
+func TestHTTPMiddleware_InvalidStoredResponse(t *testing.T) {
+	store := NewMemoryStore()
+	done := record{State: StateDone, Result: `{"statusCode":0}`}
+	_, err := store.SetNX(context.Background(), httpKeyPrefix+":key-1", done.encode(), time.Minute)
+	assert.NoError(t, err)
+
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(store))(newOrderHandler(&calls, nil))
+	rec := doRequest(handler, http.MethodPost, "key-1", `{}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, int32(0), calls)
+}
+
+func TestHTTPMiddleware_MaxBodyBytes(t *testing.T) {
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()), WithMaxBodyBytes(8))(newOrderHandler(&calls, nil))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(handler, http.MethodPost, "key-1", `{"sku":"A"}`).Code)
+	assert.Equal(t, int32(0), calls)
+	assert.Equal(t, http.StatusCreated, doRequest(handler, http.MethodPost, "key-1", `{}`).Code)
+	assert.Equal(t, int32(1), calls)
+}
@@ -0,0 +1,99 @@
+package idempotent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	Value    string    `json:"value"`
+	ExpireAt time.Time `json:"expireAt,omitempty"` // 零值表示永不过期
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.ExpireAt.IsZero() && !now.Before(e.ExpireAt)
+}
+
+// MemoryStore 进程内的存储实现，支持过期时间，适合单机部署和单元测试
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.getLocked(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return e.Value, nil
+}
+
+func (s *MemoryStore) SetNX(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.getLocked(key); ok {
+		return false, nil
+	}
+	s.setLocked(key, value, ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) CompareAndSwap(_ context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.getLocked(key); !ok || e.Value != old {
+		return false, nil
+	}
+	s.setLocked(key, value, ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) CompareAndDelete(_ context.Context, key, old string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.getLocked(key); !ok || e.Value != old {
+		return false, nil
+	}
+	delete(s.entries, key)
+	return true, nil
+}
+
+// getLocked 读取未过期的 entry，顺便清理已过期的
+func (s *MemoryStore) getLocked(key string) (memoryEntry, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if e.expired(s.now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+func (s *MemoryStore) setLocked(key, value string, ttl time.Duration) {
+	e := memoryEntry{Value: value}
+	if ttl > 0 {
+		e.ExpireAt = s.now().Add(ttl)
+	}
+	s.entries[key] = e
+}
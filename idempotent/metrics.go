@@ -0,0 +1,61 @@
+package idempotent
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Metrics 幂等守卫的计数器，可以通过 expvar.Publish 暴露
+type Metrics struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	conflicts   atomic.Int64
+	mismatches  atomic.Int64
+	storeErrors atomic.Int64
+}
+
+// MetricsSnapshot 某一时刻的计数
+type MetricsSnapshot struct {
+	// Hits 重复请求直接返回之前保存的结果
+	Hits int64 `json:"hits"`
+	// Misses 首次请求（或失败后的重试）执行了处理逻辑
+	Misses int64 `json:"misses"`
+	// Conflicts 重复请求遇到首次请求仍在处理中
+	Conflicts int64 `json:"conflicts"`
+	// Mismatches 幂等键被用于内容不同的请求
+	Mismatches int64 `json:"mismatches"`
+	// StoreErrors 访问存储出错
+	StoreErrors int64 `json:"storeErrors"`
+}
+
+// Snapshot 返回当前的计数
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Hits:        m.hits.Load(),
+		Misses:      m.misses.Load(),
+		Conflicts:   m.conflicts.Load(),
+		Mismatches:  m.mismatches.Load(),
+		StoreErrors: m.storeErrors.Load(),
+	}
+}
+
+// String 以 JSON 格式输出当前计数，实现 expvar.Var
+func (m *Metrics) String() string {
+	data, _ := json.Marshal(m.Snapshot())
+	return string(data)
+}
+
+func (m *Metrics) observe(outcome Outcome) {
+	switch outcome {
+	case OutcomeReplayed:
+		m.hits.Add(1)
+	case OutcomeExecuted, OutcomeFailed:
+		m.misses.Add(1)
+	case OutcomeInProgress:
+		m.conflicts.Add(1)
+	case OutcomeMismatch:
+		m.mismatches.Add(1)
+	case OutcomeStoreError:
+		m.storeErrors.Add(1)
+	}
+}
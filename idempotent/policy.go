@@ -0,0 +1,45 @@
+package idempotent
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPayloadTooLarge 处理结果超过策略允许保存的大小
+var ErrPayloadTooLarge = errors.New("idempotent: result payload too large")
+
+// Policy 一类操作的幂等策略，零值字段使用 Guard 的默认配置
+type Policy struct {
+	// ResultTTL 处理结果的保存时长，即重复请求被识别的窗口
+	ResultTTL time.Duration
+	// LeaseDuration 认领后 IN_PROGRESS 记录的租约时长，应大于该操作的最长耗时
+	LeaseDuration time.Duration
+	// CacheFailures 是否缓存用 Permanent 标记的失败，效果同 WithErrorCaching
+	CacheFailures bool
+	// MaxPayloadSize 编码后的处理结果最多保存多少字节，0 表示不限制
+	// 超过时本次调用照常返回结果，记录中只保存“结果过大”的标记，结果保存期内的重复请求返回 ErrPayloadTooLarge
+	MaxPayloadSize int
+}
+
+// WithPolicy 为 operation（路由、gRPC 方法名或自定义的操作名）设置幂等策略，
+// Execute 通过 WithOperation 指定操作名，HTTP 中间件默认使用请求路径，gRPC 拦截器使用完整方法名
+func WithPolicy(operation string, p Policy) GuardOption {
+	return func(g *Guard) {
+		if g.policies == nil {
+			g.policies = make(map[string]Policy)
+		}
+		g.policies[operation] = p
+	}
+}
+
+// policy 返回 operation 生效的策略，未设置的字段使用 Guard 的默认配置
+func (g *Guard) policy(operation string) Policy {
+	p := g.policies[operation]
+	if p.ResultTTL <= 0 {
+		p.ResultTTL = g.ttl
+	}
+	if p.LeaseDuration <= 0 {
+		p.LeaseDuration = g.lease
+	}
+	return p
+}
@@ -0,0 +1,111 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Defaults(t *testing.T) {
+	g := NewGuard(NewMemoryStore(), WithResultTTL(time.Hour), WithLease(time.Second),
+		WithPolicy("pay", Policy{ResultTTL: 24 * time.Hour, CacheFailures: true}))
+
+	assert.Equal(t, Policy{ResultTTL: time.Hour, LeaseDuration: time.Second}, g.policy("unknown"))
+	assert.Equal(t, Policy{ResultTTL: 24 * time.Hour, LeaseDuration: time.Second, CacheFailures: true}, g.policy("pay"))
+}
+
+func TestPolicy_ResultTTLAndLease(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	g := NewGuard(store, WithPolicy("pay", Policy{ResultTTL: time.Hour, LeaseDuration: time.Minute}))
+
+	var executed int32
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&executed, 1)
+		// 处理中的记录使用该操作的租约
+		assert.Equal(t, now.Add(time.Minute), store.entries["pay-1"].ExpireAt)
+		return "ok", nil
+	}
+	_, err := Execute(ctx, "pay-1", fn, WithGuard(g), WithOperation("pay"))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), store.entries["pay-1"].ExpireAt)
+
+	// 超过默认的 ExpirationTime 仍然能识别重复请求
+	now = now.Add(30 * time.Minute)
+	_, err = Execute(ctx, "pay-1", fn, WithGuard(g), WithOperation("pay"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), executed)
+
+	// 其他操作使用默认策略
+	_, err = Execute(ctx, "query-1", func(ctx context.Context) (string, error) { return "ok", nil }, WithGuard(g))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(ExpirationTime), store.entries["query-1"].ExpireAt)
+}
+
+func TestPolicy_CacheFailures(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(NewMemoryStore(), WithPolicy("pay", Policy{CacheFailures: true}))
+	var executed int32
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&executed, 1)
+		return "", Permanent(errors.New("insufficient balance"))
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := Execute(ctx, "pay-1", fn, WithGuard(g), WithOperation("pay"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), executed)
+
+	// 未开启的操作不缓存失败
+	for i := 0; i < 2; i++ {
+		_, err := Execute(ctx, "query-1", fn, WithGuard(g), WithOperation("query"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(3), executed)
+}
+
+func TestPolicy_MaxPayloadSize(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(NewMemoryStore(), WithPolicy("export", Policy{MaxPayloadSize: 8}))
+	var executed int32
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&executed, 1)
+		return strings.Repeat("x", 16), nil
+	}
+
+	result, err := Execute(ctx, "export-1", fn, WithGuard(g), WithOperation("export"), WithCodec(StringCodec))
+	assert.NoError(t, err)
+	assert.Len(t, result, 16)
+
+	_, err = Execute(ctx, "export-1", fn, WithGuard(g), WithOperation("export"), WithCodec(StringCodec))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	assert.Equal(t, int32(1), executed)
+}
+
+func TestHTTPMiddleware_RoutePolicy(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	guard := NewGuard(store, WithPolicy("/orders", Policy{ResultTTL: time.Hour}))
+
+	var calls int32
+	handler := NewHTTPMiddleware(guard)(newOrderHandler(&calls, nil))
+	doRequest(handler, http.MethodPost, "key-1", `{}`)
+	assert.Equal(t, now.Add(time.Hour), store.entries["http::key-1"].ExpireAt)
+
+	// 路由模板作为操作名
+	templated := NewHTTPMiddleware(guard, WithOperationFunc(func(r *http.Request) string {
+		return "/orders"
+	}))(newOrderHandler(&calls, nil))
+	doRequest(templated, http.MethodPost, "key-2", `{}`)
+	assert.Equal(t, now.Add(time.Hour), store.entries["http::key-2"].ExpireAt)
+}
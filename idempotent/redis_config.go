@@ -0,0 +1,160 @@
+package idempotent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisMode Redis 的部署方式
+type RedisMode string
+
+const (
+	// RedisStandalone 单节点，Addrs 只有一个地址
+	RedisStandalone RedisMode = "standalone"
+	// RedisSentinel 哨兵模式，Addrs 为哨兵节点地址，需要 MasterName
+	RedisSentinel RedisMode = "sentinel"
+	// RedisCluster 集群模式，Addrs 为部分或全部集群节点地址；集群模式不支持 DB
+	RedisCluster RedisMode = "cluster"
+)
+
+// ErrRedisInitialized 共享的 Redis 客户端已经创建，不能再修改配置
+var ErrRedisInitialized = errors.New("idempotent: redis client already initialized")
+
+// RedisConfig 幂等存储使用的 Redis 配置，可以用 go-zero 的 conf.Load 从配置文件加载
+type RedisConfig struct {
+	Mode       RedisMode `json:",default=standalone,options=standalone|sentinel|cluster"`
+	Addrs      []string
+	MasterName string `json:",optional"`
+	Password   string `json:",optional"`
+	DB         int    `json:",optional"`
+
+	// 连接池，零值使用 go-redis 的默认值
+	PoolSize     int           `json:",optional"`
+	MinIdleConns int           `json:",optional"`
+	PoolTimeout  time.Duration `json:",optional"`
+	IdleTimeout  time.Duration `json:",optional"`
+	MaxConnAge   time.Duration `json:",optional"`
+	DialTimeout  time.Duration `json:",optional"`
+	ReadTimeout  time.Duration `json:",optional"`
+	WriteTimeout time.Duration `json:",optional"`
+	MaxRetries   int           `json:",optional"`
+}
+
+// DefaultRedisConfig 由包常量 Addr、Password、DB 组成的单节点配置
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Mode:     RedisStandalone,
+		Addrs:    []string{Addr},
+		Password: Password,
+		DB:       DB,
+	}
+}
+
+// Validate 检查各模式必需的配置
+func (c RedisConfig) Validate() error {
+	if len(c.Addrs) == 0 {
+		return errors.New("idempotent: redis addrs are required")
+	}
+	switch c.Mode {
+	case RedisStandalone, "":
+		if len(c.Addrs) != 1 {
+			return fmt.Errorf("idempotent: standalone redis expects 1 addr, got %d", len(c.Addrs))
+		}
+	case RedisSentinel:
+		if c.MasterName == "" {
+			return errors.New("idempotent: sentinel redis requires master name")
+		}
+	case RedisCluster:
+		if c.DB != 0 {
+			return errors.New("idempotent: cluster redis does not support db")
+		}
+	default:
+		return fmt.Errorf("idempotent: unknown redis mode %q", c.Mode)
+	}
+	return nil
+}
+
+// NewClient 按部署方式创建客户端，每次调用都会新建连接池；进程内共享使用 InitRedis 和 RedisInit
+func (c RedisConfig) NewClient() (redis.UniversalClient, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Mode {
+	case RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    c.MasterName,
+			SentinelAddrs: c.Addrs,
+			Password:      c.Password,
+			DB:            c.DB,
+			MaxRetries:    c.MaxRetries,
+			DialTimeout:   c.DialTimeout,
+			ReadTimeout:   c.ReadTimeout,
+			WriteTimeout:  c.WriteTimeout,
+			PoolSize:      c.PoolSize,
+			MinIdleConns:  c.MinIdleConns,
+			MaxConnAge:    c.MaxConnAge,
+			PoolTimeout:   c.PoolTimeout,
+			IdleTimeout:   c.IdleTimeout,
+		}), nil
+	case RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        c.Addrs,
+			Password:     c.Password,
+			MaxRetries:   c.MaxRetries,
+			DialTimeout:  c.DialTimeout,
+			ReadTimeout:  c.ReadTimeout,
+			WriteTimeout: c.WriteTimeout,
+			PoolSize:     c.PoolSize,
+			MinIdleConns: c.MinIdleConns,
+			MaxConnAge:   c.MaxConnAge,
+			PoolTimeout:  c.PoolTimeout,
+			IdleTimeout:  c.IdleTimeout,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         c.Addrs[0],
+			Password:     c.Password,
+			DB:           c.DB,
+			MaxRetries:   c.MaxRetries,
+			DialTimeout:  c.DialTimeout,
+			ReadTimeout:  c.ReadTimeout,
+			WriteTimeout: c.WriteTimeout,
+			PoolSize:     c.PoolSize,
+			MinIdleConns: c.MinIdleConns,
+			MaxConnAge:   c.MaxConnAge,
+			PoolTimeout:  c.PoolTimeout,
+			IdleTimeout:  c.IdleTimeout,
+		}), nil
+	}
+}
+
+var (
+	sharedRedisMu     sync.Mutex
+	sharedRedisConfig = DefaultRedisConfig()
+	sharedRedis       redis.UniversalClient
+)
+
+// InitRedis 设置并创建进程共享的 Redis 客户端，需要在第一次调用 RedisInit、SharedRedis（DefaultGuard）之前调用
+func InitRedis(cfg RedisConfig) error {
+	sharedRedisMu.Lock()
+	defer sharedRedisMu.Unlock()
+	if sharedRedis != nil {
+		return ErrRedisInitialized
+	}
+	client, err := cfg.NewClient()
+	if err != nil {
+		return err
+	}
+	setSharedRedisLocked(cfg, client)
+	return nil
+}
+
+func setSharedRedisLocked(cfg RedisConfig, client redis.UniversalClient) {
+	sharedRedisConfig, sharedRedis = cfg, client
+	redisClient, _ = client.(*redis.Client)
+}
@@ -0,0 +1,104 @@
+package idempotent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisConfig_Validate(t *testing.T) {
+	assert.NoError(t, DefaultRedisConfig().Validate())
+	assert.NoError(t, RedisConfig{Addrs: []string{"127.0.0.1:6379"}}.Validate())
+
+	assert.Error(t, RedisConfig{Mode: RedisStandalone}.Validate())
+	assert.Error(t, RedisConfig{Mode: RedisStandalone, Addrs: []string{"a:6379", "b:6379"}}.Validate())
+	assert.Error(t, RedisConfig{Mode: RedisSentinel, Addrs: []string{"a:26379"}}.Validate())
+	assert.NoError(t, RedisConfig{Mode: RedisSentinel, Addrs: []string{"a:26379"}, MasterName: "mymaster"}.Validate())
+	assert.Error(t, RedisConfig{Mode: RedisCluster, Addrs: []string{"a:7000"}, DB: 1}.Validate())
+	assert.Error(t, RedisConfig{Mode: "replica", Addrs: []string{"a:6379"}}.Validate())
+
+	_, err := RedisConfig{Mode: RedisSentinel, Addrs: []string{"a:26379"}}.NewClient()
+	assert.Error(t, err)
+}
+
+func TestRedisConfig_NewClient(t *testing.T) {
+	m := miniredis.RunT(t)
+	ctx := context.Background()
+
+	sentinel, err := RedisConfig{Mode: RedisSentinel, Addrs: []string{m.Addr()}, MasterName: "mymaster"}.NewClient()
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, sentinel)
+	_ = sentinel.Close()
+
+	cases := map[string]RedisConfig{
+		"standalone": {Mode: RedisStandalone, Addrs: []string{m.Addr()}, PoolSize: 4, MinIdleConns: 1},
+		"cluster":    {Mode: RedisCluster, Addrs: []string{m.Addr()}, PoolSize: 4},
+	}
+	for name, cfg := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := cfg.NewClient()
+			assert.NoError(t, err)
+			defer client.Close()
+
+			store := NewRedisStore(client)
+			ok, err := store.SetNX(ctx, name, "v1", 0)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			swapped, err := store.CompareAndSwap(ctx, name, "v1", "v2", 0)
+			assert.NoError(t, err)
+			assert.True(t, swapped)
+			val, err := store.Get(ctx, name)
+			assert.NoError(t, err)
+			assert.Equal(t, "v2", val)
+		})
+	}
+}
+
+func TestInitRedis_Shared(t *testing.T) {
+	m := miniredis.RunT(t)
+	sharedRedisMu.Lock()
+	saved, savedConfig, savedClient := sharedRedis, sharedRedisConfig, redisClient
+	sharedRedis = nil
+	sharedRedisMu.Unlock()
+	defer func() {
+		sharedRedisMu.Lock()
+		sharedRedis, sharedRedisConfig, redisClient = saved, savedConfig, savedClient
+		sharedRedisMu.Unlock()
+	}()
+
+	assert.Error(t, InitRedis(RedisConfig{Mode: RedisSentinel, Addrs: []string{m.Addr()}}))
+	assert.NoError(t, InitRedis(RedisConfig{Addrs: []string{m.Addr()}}))
+	assert.ErrorIs(t, InitRedis(RedisConfig{Addrs: []string{m.Addr()}}), ErrRedisInitialized)
+
+	client := RedisInit()
+	assert.Same(t, client, RedisInit())
+	assert.Same(t, client, SharedRedis())
+	assert.Same(t, client, redisClient)
+	assert.NoError(t, client.Set("k", "v", 0).Err())
+	m.CheckGet(t, "k", "v")
+	_ = client.Close()
+}
+
+func TestRedisInit_Cluster(t *testing.T) {
+	m := miniredis.RunT(t)
+	sharedRedisMu.Lock()
+	saved, savedConfig, savedClient := sharedRedis, sharedRedisConfig, redisClient
+	sharedRedis = nil
+	sharedRedisMu.Unlock()
+	defer func() {
+		sharedRedisMu.Lock()
+		sharedRedis, sharedRedisConfig, redisClient = saved, savedConfig, savedClient
+		sharedRedisMu.Unlock()
+	}()
+
+	// 集群模式只能通过 SharedRedis 使用
+	assert.NoError(t, InitRedis(RedisConfig{Mode: RedisCluster, Addrs: []string{m.Addr()}}))
+	client := SharedRedis()
+	assert.IsType(t, &redis.ClusterClient{}, client)
+	assert.Nil(t, redisClient)
+	assert.Nil(t, RedisInit())
+	_ = client.Close()
+}
@@ -0,0 +1,153 @@
+package idempotent
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// compareAndSwapScript KEYS[1]=key ARGV[1]=old ARGV[2]=value ARGV[3]=ttl(毫秒，0 表示不过期)
+	compareAndSwapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+
+	// compareAndDeleteScript KEYS[1]=key ARGV[1]=old
+	compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisStore 基于 Redis 的存储实现，多实例部署时共享幂等状态
+// go-redis v6 的命令不支持 context，ctx 参数目前仅为满足接口
+type RedisStore struct {
+	client redis.Cmdable
+}
+
+// NewRedisStore client 可以是 *redis.Client、*redis.ClusterClient 等任意实现了 redis.Cmdable 的客户端
+func NewRedisStore(client redis.Cmdable) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Get(_ context.Context, key string) (string, error) {
+	val, err := s.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return val, err
+}
+
+func (s *RedisStore) SetNX(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(key, value, redisTTL(ttl)).Result()
+}
+
+func (s *RedisStore) Delete(_ context.Context, key string) error {
+	return s.client.Del(key).Err()
+}
+
+func (s *RedisStore) CompareAndSwap(_ context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	n, err := compareAndSwapScript.Run(s.client, []string{key}, old, value, redisTTL(ttl).Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *RedisStore) CompareAndDelete(_ context.Context, key, old string) (bool, error) {
+	n, err := compareAndDeleteScript.Run(s.client, []string{key}, old).Int()
+	return n == 1, err
+}
+
+// redisTTL Redis 的过期时间精度为毫秒：负数视为不过期，不足 1ms 的正数向上取整为 1ms，
+// 否则会被截断为 0，SET 报错、compareAndSwapScript 则会把 key 当成永不过期
+func redisTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl > 0 && ttl < time.Millisecond:
+		return time.Millisecond
+	}
+	return ttl
+}
+
+// SetNXBatch 在一个 pipeline 中发送所有 SET NX
+func (s *RedisStore) SetNXBatch(_ context.Context, keys, values []string, ttl time.Duration) ([]bool, error) {
+	ttl = redisTTL(ttl)
+	cmds := make([]*redis.BoolCmd, len(keys))
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.SetNX(key, values[i], ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		ok[i] = cmd.Val()
+	}
+	return ok, nil
+}
+
+// GetBatch 使用 pipeline 而不是 MGET，Cluster 模式下 key 可以分布在不同的 slot
+func (s *RedisStore) GetBatch(_ context.Context, keys []string) (map[string]string, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(keys))
+	for i, cmd := range cmds {
+		if cmd.Err() == nil {
+			values[keys[i]] = cmd.Val()
+		}
+	}
+	return values, nil
+}
+
+// CompareAndSwapBatch pipeline 中使用 EVAL 而不是 EVALSHA，避免脚本未加载时整批失败
+func (s *RedisStore) CompareAndSwapBatch(_ context.Context, entries []SwapEntry, ttl time.Duration) ([]bool, error) {
+	return s.evalBatch(len(entries), func(pipe redis.Pipeliner, i int) *redis.Cmd {
+		e := entries[i]
+		return compareAndSwapScript.Eval(pipe, []string{e.Key}, e.Old, e.Value, redisTTL(ttl).Milliseconds())
+	})
+}
+
+func (s *RedisStore) CompareAndDeleteBatch(_ context.Context, entries []SwapEntry) ([]bool, error) {
+	return s.evalBatch(len(entries), func(pipe redis.Pipeliner, i int) *redis.Cmd {
+		e := entries[i]
+		return compareAndDeleteScript.Eval(pipe, []string{e.Key}, e.Old)
+	})
+}
+
+func (s *RedisStore) evalBatch(n int, eval func(pipe redis.Pipeliner, i int) *redis.Cmd) ([]bool, error) {
+	cmds := make([]*redis.Cmd, n)
+	_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i := 0; i < n; i++ {
+			cmds[i] = eval(pipe, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok := make([]bool, n)
+	for i, cmd := range cmds {
+		v, _ := cmd.Int()
+		ok[i] = v == 1
+	}
+	return ok, nil
+}
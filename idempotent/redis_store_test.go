@@ -0,0 +1,225 @@
+package idempotent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMiniRedisStore 基于进程内 Redis 协议服务（miniredis）的 RedisStore，走真实的网络协议和 Lua 脚本
+func newMiniRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr(), PoolSize: 64})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisStore(client), m
+}
+
+func TestRedisStore(t *testing.T) {
+	store, _ := newMiniRedisStore(t)
+	testStoreContract(t, store)
+
+	ctx := context.Background()
+	ok, err := store.CompareAndDelete(ctx, "k2", "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRedisStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	store, m := newMiniRedisStore(t)
+
+	_, _ = store.SetNX(ctx, "short", "v", time.Second)
+	_, _ = store.SetNX(ctx, "forever", "v", 0)
+	ok, err := store.CompareAndSwap(ctx, "forever", "v", "v2", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	m.FastForward(time.Second)
+	_, err = store.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrNotFound)
+	val, err := store.Get(ctx, "forever")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", val)
+}
+
+func TestRedisStore_SubMillisecondTTL(t *testing.T) {
+	ctx := context.Background()
+	store, m := newMiniRedisStore(t)
+
+	// 不足 1ms 的过期时间向上取整，不会被截断为 0 变成永不过期
+	ok, err := store.SetNX(ctx, "k1", "v1", time.Microsecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = store.CompareAndSwap(ctx, "k1", "v1", "v2", time.Microsecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, m.TTL("k1"))
+
+	swapped, err := store.CompareAndSwapBatch(ctx, []SwapEntry{{Key: "k1", Old: "v2", Value: "v3"}}, time.Microsecond)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true}, swapped)
+	assert.Equal(t, time.Millisecond, m.TTL("k1"))
+}
+
+// TestRedisGuard_HammerSameKey 数百个 goroutine 同时用同一个 key 请求，副作用只执行一次，所有调用拿到同样的结果
+func TestRedisGuard_HammerSameKey(t *testing.T) {
+	store, _ := newMiniRedisStore(t)
+	guard := NewGuard(store, WithWait(10*time.Second, 5*time.Millisecond))
+
+	const n = 300
+	var (
+		sideEffects int32
+		start       sync.WaitGroup
+		done        sync.WaitGroup
+	)
+	results := make([]string, n)
+	errs := make([]error, n)
+	start.Add(1)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			start.Wait()
+			results[i], errs[i] = Execute(context.Background(), "charge-1", func(ctx context.Context) (string, error) {
+				seq := atomic.AddInt32(&sideEffects, 1)
+				time.Sleep(50 * time.Millisecond)
+				return fmt.Sprintf("charged #%d", seq), nil
+			}, WithGuard(guard))
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sideEffects))
+	for i := 0; i < n; i++ {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, "charged #1", results[i])
+	}
+}
+
+// TestRedisGuard_HammerManyKeys 多个 key 并发请求，每个 key 各自只执行一次
+func TestRedisGuard_HammerManyKeys(t *testing.T) {
+	store, _ := newMiniRedisStore(t)
+	guard := NewGuard(store, WithWait(10*time.Second, 5*time.Millisecond))
+
+	const keys, perKey = 20, 20
+	var counts [keys]int32
+	var wg sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		for j := 0; j < perKey; j++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				_, err := Execute(context.Background(), fmt.Sprintf("order-%d", k), func(ctx context.Context) (int, error) {
+					atomic.AddInt32(&counts[k], 1)
+					time.Sleep(10 * time.Millisecond)
+					return k, nil
+				}, WithGuard(guard))
+				assert.NoError(t, err)
+			}(k)
+		}
+	}
+	wg.Wait()
+
+	for k := 0; k < keys; k++ {
+		assert.Equal(t, int32(1), counts[k], "key %d", k)
+	}
+}
+
+// TestRedisGuard_CrashDuringWork 处理者认领后崩溃：租约到期前重复请求都被拒绝，到期后并发重试中只有一个重新执行
+func TestRedisGuard_CrashDuringWork(t *testing.T) {
+	ctx := context.Background()
+	store, m := newMiniRedisStore(t)
+
+	// 另一个实例认领后进程崩溃，IN_PROGRESS 记录留在 Redis 中直到租约到期
+	ok, err := store.SetNX(ctx, "charge-2", record{State: StateInProgress, Owner: "crashed"}.encode(), 5*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	var sideEffects int32
+	guard := NewGuard(store)
+	charge := func() (string, error) {
+		return Execute(ctx, "charge-2", func(ctx context.Context) (string, error) {
+			atomic.AddInt32(&sideEffects, 1)
+			time.Sleep(20 * time.Millisecond)
+			return "charged", nil
+		}, WithGuard(guard))
+	}
+
+	_, errs := runConcurrently(100, charge)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, ErrRequestInProgress)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sideEffects))
+
+	// 租约到期，并发的重试中只有一个重新执行，其余的返回处理中或已保存的结果
+	m.FastForward(5 * time.Second)
+	results, errs := runConcurrently(100, charge)
+	var succeeded int
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+			assert.Equal(t, "charged", results[i])
+		} else {
+			assert.ErrorIs(t, err, ErrRequestInProgress)
+		}
+	}
+	assert.GreaterOrEqual(t, succeeded, 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sideEffects))
+
+	result, err := charge()
+	assert.NoError(t, err)
+	assert.Equal(t, "charged", result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sideEffects))
+}
+
+// TestRedisGuard_LeaseExpiresDuringWork 处理时间超过租约：新的处理者重新认领，旧处理者的结果不会覆盖新记录
+func TestRedisGuard_LeaseExpiresDuringWork(t *testing.T) {
+	ctx := context.Background()
+	store, m := newMiniRedisStore(t)
+	guard := NewGuard(store, WithLease(time.Second))
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	slowDone := make(chan string)
+	go func() {
+		result, err := Execute(ctx, "charge-3", func(ctx context.Context) (string, error) {
+			close(entered)
+			<-release
+			return "slow", nil
+		}, WithGuard(guard))
+		assert.NoError(t, err)
+		slowDone <- result
+	}()
+	<-entered
+
+	_, err := Execute(ctx, "charge-3", func(ctx context.Context) (string, error) {
+		return "fast", nil
+	}, WithGuard(guard))
+	assert.ErrorIs(t, err, ErrRequestInProgress)
+
+	// 租约到期后被重新认领并完成
+	m.FastForward(time.Second)
+	result, err := Execute(ctx, "charge-3", func(ctx context.Context) (string, error) {
+		return "fast", nil
+	}, WithGuard(guard))
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", result)
+
+	// 旧处理者完成时已不持有租约，保存的仍是新处理者的结果
+	close(release)
+	assert.Equal(t, "slow", <-slowDone)
+	replay, err := Execute(ctx, "charge-3", func(ctx context.Context) (string, error) {
+		t.Error("should not execute again")
+		return "", nil
+	}, WithGuard(guard))
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", replay)
+}
@@ -0,0 +1,274 @@
+package idempotent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxAttempts  = 3
+	defaultBackoffBase  = 100 * time.Millisecond
+	defaultBackoffLimit = 2 * time.Second
+)
+
+// Attempt 一次请求尝试的结果
+type Attempt struct {
+	Number     int
+	StatusCode int // 没有收到响应时为 0
+	Err        error
+	Duration   time.Duration
+}
+
+// RetryError 所有尝试都失败，或遇到不可重试的错误时返回
+type RetryError struct {
+	Token    string
+	Attempts []Attempt // 实际发送过的每一次请求
+	// Canceled 等待下一次重试期间请求的 ctx 被取消或超时，此时不再发送请求
+	Canceled error
+}
+
+func (e *RetryError) Error() string {
+	parts := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		switch {
+		case a.Err != nil:
+			parts[i] = fmt.Sprintf("#%d: %v", a.Number, a.Err)
+		default:
+			parts[i] = fmt.Sprintf("#%d: status %d", a.Number, a.StatusCode)
+		}
+	}
+	msg := fmt.Sprintf("idempotent: request with token %s failed after %d attempts (%s)",
+		e.Token, len(e.Attempts), strings.Join(parts, "; "))
+	if e.Canceled != nil {
+		msg += fmt.Sprintf(", retry canceled: %v", e.Canceled)
+	}
+	return msg
+}
+
+// Unwrap 返回 Canceled，没有被取消时返回最后一次尝试的错误
+func (e *RetryError) Unwrap() error {
+	if e.Canceled != nil {
+		return e.Canceled
+	}
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// RetryOption RetryClient 的可选项
+type RetryOption func(*RetryClient)
+
+// WithHTTPClient 实际发送请求的客户端，默认 http.DefaultClient
+func WithHTTPClient(client *http.Client) RetryOption {
+	return func(c *RetryClient) {
+		c.client = client
+	}
+}
+
+// WithMaxAttempts 最多尝试的次数（包括第一次），默认 3
+func WithMaxAttempts(n int) RetryOption {
+	return func(c *RetryClient) {
+		c.maxAttempts = n
+	}
+}
+
+// WithBackoff 第 n 次重试前随机等待 0 到 base*2^(n-1)（full jitter），上限为 limit，默认 100ms 到 2s
+func WithBackoff(base, limit time.Duration) RetryOption {
+	return func(c *RetryClient) {
+		c.backoffBase = base
+		c.backoffLimit = limit
+	}
+}
+
+// WithAttemptTimeout 单次尝试的超时时间，默认不限制（由请求的 ctx 和 http.Client.Timeout 控制）
+func WithAttemptTimeout(timeout time.Duration) RetryOption {
+	return func(c *RetryClient) {
+		c.attemptTimeout = timeout
+	}
+}
+
+// WithRetryTokenHeader 携带幂等键的请求头，默认 Idempotency-Key
+func WithRetryTokenHeader(header string) RetryOption {
+	return func(c *RetryClient) {
+		c.header = header
+	}
+}
+
+// WithTokenOptions 生成幂等键时传给 GenerateToken 的可选项，如 WithSigner
+func WithTokenOptions(opts ...TokenOption) RetryOption {
+	return func(c *RetryClient) {
+		c.tokenOpts = opts
+	}
+}
+
+// RetryClient 调用第三方接口的 HTTP 客户端：每个请求用 GenerateToken 生成一个幂等键，
+// 所有重试都带上同一个幂等键，服务端据此保证重试不会被重复处理
+//
+// 超时、5xx 和带有 Retry-After 的 409（上一次尝试仍在服务端处理中）会按带随机抖动的指数退避重试，其他响应直接返回；
+// 不带 Retry-After 的 409（如 token 已被使用）重试也不会成功
+type RetryClient struct {
+	client         *http.Client
+	header         string
+	maxAttempts    int
+	backoffBase    time.Duration
+	backoffLimit   time.Duration
+	attemptTimeout time.Duration
+	tokenOpts      []TokenOption
+}
+
+// NewRetryClient 创建重试客户端
+func NewRetryClient(opts ...RetryOption) *RetryClient {
+	c := &RetryClient{
+		client:       http.DefaultClient,
+		header:       IdempotencyKeyHeader,
+		maxAttempts:  defaultMaxAttempts,
+		backoffBase:  defaultBackoffBase,
+		backoffLimit: defaultBackoffLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxAttempts < 1 {
+		c.maxAttempts = 1
+	}
+	return c
+}
+
+// Do 发送请求，请求已带有幂等键时沿用它；成功时返回的响应由调用方关闭，
+// 重试用尽或遇到不可重试的错误时返回 *RetryError，其中记录了每一次尝试
+func (c *RetryClient) Do(req *http.Request) (*http.Response, error) {
+	token := req.Header.Get(c.header)
+	if token == "" {
+		token = GenerateToken(c.tokenOpts...)
+	}
+
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	retryErr := &RetryError{Token: token}
+	ctx := req.Context()
+	for n := 1; ; n++ {
+		resp, attempt := c.attempt(ctx, req, getBody, token, n)
+		if resp != nil && !retryableResponse(resp) {
+			return resp, nil
+		}
+		retryErr.Attempts = append(retryErr.Attempts, attempt)
+		if resp != nil {
+			// 丢弃需要重试的响应，连接可以复用
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		if attempt.Err != nil && !retryableError(ctx, attempt.Err) {
+			return nil, retryErr
+		}
+		if n >= c.maxAttempts {
+			return nil, retryErr
+		}
+
+		select {
+		case <-ctx.Done():
+			retryErr.Canceled = ctx.Err()
+			return nil, retryErr
+		case <-time.After(c.backoff(n)):
+		}
+	}
+}
+
+// attempt 发送第 n 次请求
+func (c *RetryClient) attempt(ctx context.Context, req *http.Request, getBody func() (io.ReadCloser, error),
+	token string, n int) (*http.Response, Attempt) {
+	start := time.Now()
+	attempt := Attempt{Number: n}
+
+	cancel := context.CancelFunc(func() {})
+	if c.attemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.attemptTimeout)
+	}
+	r := req.Clone(ctx)
+	r.Header.Set(c.header, token)
+	if getBody != nil {
+		body, err := getBody()
+		if err != nil {
+			cancel()
+			attempt.Err = err
+			return nil, attempt
+		}
+		r.Body = body
+	}
+
+	resp, err := c.client.Do(r)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		cancel()
+		attempt.Err = err
+		return nil, attempt
+	}
+	attempt.StatusCode = resp.StatusCode
+	// 响应体读完关闭时才结束本次尝试的超时
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, attempt
+}
+
+// backoff 第 n 次重试前的等待时间，在 0 到指数退避上限之间随机取值，避免大量客户端同时重试
+func (c *RetryClient) backoff(n int) time.Duration {
+	d := c.backoffBase
+	for i := 1; i < n && d < c.backoffLimit; i++ {
+		d *= 2
+	}
+	if d > c.backoffLimit {
+		d = c.backoffLimit
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryableResponse 5xx 需要重试；409 只有在服务端通过 Retry-After 表示请求仍在处理中时才重试
+func retryableResponse(resp *http.Response) bool {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	return resp.StatusCode == http.StatusConflict && resp.Header.Get("Retry-After") != ""
+}
+
+// retryableError 只有超时需要重试；请求的 ctx 本身被取消或超时则不再重试
+func retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,202 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryClient_ReusesTokenAcrossRetries(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tokens []string
+		bodies []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		tokens = append(tokens, r.Header.Get(IdempotencyKeyHeader))
+		bodies = append(bodies, string(body))
+		n := len(tokens)
+		mu.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	client := NewRetryClient(WithBackoff(time.Millisecond, 5*time.Millisecond))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"sku":"A"}`))
+	req.GetBody = nil // 模拟无法重新获取请求体的请求
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	assert.Len(t, tokens, 3)
+	assert.NotEmpty(t, tokens[0])
+	assert.Equal(t, tokens[0], tokens[1])
+	assert.Equal(t, tokens[0], tokens[2])
+	assert.Equal(t, []string{`{"sku":"A"}`, `{"sku":"A"}`, `{"sku":"A"}`}, bodies)
+}
+
+func TestRetryClient_ExhaustedWithHistory(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// 第一次尝试超时
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := NewRetryClient(WithMaxAttempts(3), WithBackoff(time.Millisecond, time.Millisecond),
+		WithAttemptTimeout(30*time.Millisecond))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	req.Header.Set(IdempotencyKeyHeader, "order-1")
+	_, err := client.Do(req)
+
+	var retryErr *RetryError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, "order-1", retryErr.Token)
+	assert.Len(t, retryErr.Attempts, 3)
+	assert.ErrorIs(t, retryErr.Attempts[0].Err, context.DeadlineExceeded)
+	assert.Equal(t, http.StatusBadGateway, retryErr.Attempts[1].StatusCode)
+	assert.Equal(t, http.StatusBadGateway, retryErr.Attempts[2].StatusCode)
+	assert.Contains(t, err.Error(), "order-1")
+}
+
+func TestRetryClient_CanceledDuringBackoff(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client := NewRetryClient(WithMaxAttempts(5), WithBackoff(time.Second, time.Second))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	_, err := client.Do(req)
+
+	// 只记录实际发送过的请求，取消单独记录
+	var retryErr *RetryError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Len(t, retryErr.Attempts, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, retryErr.Attempts[0].StatusCode)
+	assert.ErrorIs(t, retryErr.Canceled, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "after 1 attempts")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryClient_DoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	resp, err := NewRetryClient().Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 连接失败不是超时，不重试
+	srv.Close()
+	req, _ = http.NewRequest(http.MethodPost, srv.URL, nil)
+	_, err = NewRetryClient().Do(req)
+	var retryErr *RetryError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Len(t, retryErr.Attempts, 1)
+}
+
+func TestRetryClient_WithGuardMiddleware(t *testing.T) {
+	var calls int32
+	handler := NewHTTPMiddleware(NewGuard(NewMemoryStore()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// 处理完成但响应超时，客户端重试后拿到保存的结果
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// 退避时间随机，多给几次机会等首次请求处理完
+	client := NewRetryClient(WithMaxAttempts(10), WithBackoff(20*time.Millisecond, 50*time.Millisecond),
+		WithAttemptTimeout(30*time.Millisecond))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(IdempotentReplayedHeader))
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryClient_ConflictNeedsRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/in-progress" && n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if r.URL.Path == "/consumed" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	client := NewRetryClient(WithBackoff(time.Millisecond, time.Millisecond))
+
+	// token 已被使用：重试也不会成功，直接返回
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/consumed", nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 仍在处理中：按 Retry-After 重试
+	atomic.StoreInt32(&calls, 0)
+	req, _ = http.NewRequest(http.MethodPost, srv.URL+"/in-progress", nil)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRetryClient_BackoffJitter(t *testing.T) {
+	client := NewRetryClient(WithBackoff(100*time.Millisecond, time.Second))
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		for n, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+			d := client.backoff(n)
+			assert.True(t, d >= 0 && d <= limit, "attempt %d: %v", n, d)
+			distinct[d] = true
+		}
+	}
+	// 等待时间随机分布，而不是每个客户端都相同
+	assert.Greater(t, len(distinct), 10)
+}
@@ -0,0 +1,200 @@
+package idempotent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenMalformed  = errors.New("idempotent: malformed token")
+	ErrTokenSignature  = errors.New("idempotent: invalid token signature")
+	ErrTokenKeyUnknown = errors.New("idempotent: unknown token key id")
+	ErrTokenIssuer     = errors.New("idempotent: token issued by a different issuer")
+)
+
+const signedTokenKeyPrefix = "signed-token:"
+
+// TokenClaims 签名 token 中携带的信息
+type TokenClaims struct {
+	KeyID     string `json:"kid"`
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"` // unix 秒
+	Nonce     string `json:"nonce"`
+}
+
+// TokenSigner 签发和校验 HMAC-SHA256 签名的无状态 token，签发时不需要访问存储
+// token 格式为 base64url(claims JSON) + "." + base64url(签名)
+//
+// 密钥轮换：先 AddKey 新密钥并 UseKey 切换签发密钥，旧密钥保留到它签发的 token 全部过期后再 RemoveKey
+type TokenSigner struct {
+	issuer string
+	now    func() time.Time
+
+	mu           sync.RWMutex
+	keys         map[string][]byte
+	currentKeyID string
+}
+
+// NewTokenSigner keys 为 key ID 到密钥的映射，currentKeyID 为当前用于签发的密钥
+func NewTokenSigner(issuer, currentKeyID string, keys map[string][]byte) (*TokenSigner, error) {
+	s := &TokenSigner{
+		issuer: issuer,
+		now:    time.Now,
+		keys:   make(map[string][]byte, len(keys)),
+	}
+	for kid, key := range keys {
+		s.keys[kid] = key
+	}
+	if err := s.UseKey(currentKeyID); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddKey 添加（或替换）一个密钥，添加后即可用于校验
+func (s *TokenSigner) AddKey(kid string, key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+// UseKey 切换签发 token 使用的密钥
+func (s *TokenSigner) UseKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[kid]; !ok {
+		return fmt.Errorf("%w: %s", ErrTokenKeyUnknown, kid)
+	}
+	s.currentKeyID = kid
+	return nil
+}
+
+// RemoveKey 移除密钥，之后用它签发的 token 校验失败；不能移除当前签发密钥
+func (s *TokenSigner) RemoveKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kid == s.currentKeyID {
+		return fmt.Errorf("idempotent: cannot remove current signing key %s", kid)
+	}
+	delete(s.keys, kid)
+	return nil
+}
+
+// Sign 为 subject 签发有效期为 ttl 的 token
+func (s *TokenSigner) Sign(subject string, ttl time.Duration) string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	s.mu.RLock()
+	kid := s.currentKeyID
+	key := s.keys[kid]
+	s.mu.RUnlock()
+
+	payload, _ := json.Marshal(TokenClaims{
+		KeyID:     kid,
+		Issuer:    s.issuer,
+		Subject:   subject,
+		ExpiresAt: s.now().Add(ttl).Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+	})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(key, encoded))
+}
+
+// Verify 校验签名、签发者和有效期，不访问存储，因此无法发现重放，需要防重放时使用 Consume
+func (s *TokenSigner) Verify(token string) (*TokenClaims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrTokenMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	// 验签前 claims 不可信，只用 kid 查找密钥
+	s.mu.RLock()
+	key, ok := s.keys[claims.KeyID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTokenKeyUnknown, claims.KeyID)
+	}
+	if !hmac.Equal(mac, sign(key, encoded)) {
+		return nil, ErrTokenSignature
+	}
+
+	if claims.Issuer != s.issuer {
+		return nil, fmt.Errorf("%w: %q", ErrTokenIssuer, claims.Issuer)
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// Consume 校验 token 属于 subject，并在 store 中记录 nonce 已被使用，同一个 token 只能成功使用一次
+// 记录保存到 token 过期为止，过期后 Verify 本身就会拒绝
+func (s *TokenSigner) Consume(ctx context.Context, store IdempotencyStore, token, subject string) (*TokenClaims, error) {
+	claims, err := s.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != subject {
+		return nil, ErrTokenMismatch
+	}
+
+	ttl := time.Unix(claims.ExpiresAt, 0).Sub(s.now())
+	ok, err := store.SetNX(ctx, signedTokenKeyPrefix+claims.Nonce, claims.Subject, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTokenConsumed
+	}
+	return claims, nil
+}
+
+func sign(key []byte, encoded string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+// TokenOption GenerateToken 的可选项
+type TokenOption func(*tokenOptions)
+
+type tokenOptions struct {
+	signer  *TokenSigner
+	subject string
+	ttl     time.Duration
+}
+
+// WithSigner 生成签名 token 代替随机 UUID，token 中带有 subject 和过期时间
+func WithSigner(signer *TokenSigner, subject string, ttl time.Duration) TokenOption {
+	return func(o *tokenOptions) {
+		o.signer = signer
+		o.subject = subject
+		o.ttl = ttl
+	}
+}
@@ -0,0 +1,103 @@
+package idempotent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigner(t *testing.T) *TokenSigner {
+	s, err := NewTokenSigner("order-service", "k1", map[string][]byte{"k1": []byte("secret-1")})
+	assert.NoError(t, err)
+	return s
+}
+
+func TestTokenSigner_SignAndVerify(t *testing.T) {
+	s := newTestSigner(t)
+	token := GenerateToken(WithSigner(s, "user-1", time.Minute))
+
+	claims, err := s.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", claims.KeyID)
+	assert.Equal(t, "order-service", claims.Issuer)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.NotEmpty(t, claims.Nonce)
+
+	// 两次签发的 token 不同
+	assert.NotEqual(t, token, GenerateToken(WithSigner(s, "user-1", time.Minute)))
+
+	// 不带签名器时仍然生成 UUID
+	assert.Len(t, GenerateToken(), 36)
+}
+
+func TestTokenSigner_Rejects(t *testing.T) {
+	s := newTestSigner(t)
+	token := s.Sign("user-1", time.Minute)
+	payload, sig, _ := strings.Cut(token, ".")
+
+	_, err := s.Verify("not-a-token")
+	assert.ErrorIs(t, err, ErrTokenMalformed)
+
+	// 篡改 claims：换成另一个 token 的 claims，签名对不上
+	otherPayload, _, _ := strings.Cut(s.Sign("user-2", time.Hour), ".")
+	_, err = s.Verify(otherPayload + "." + sig)
+	assert.ErrorIs(t, err, ErrTokenSignature)
+
+	// 其他签发者
+	other, err := NewTokenSigner("pay-service", "k1", map[string][]byte{"k1": []byte("secret-1")})
+	assert.NoError(t, err)
+	_, err = s.Verify(other.Sign("user-1", time.Minute))
+	assert.ErrorIs(t, err, ErrTokenIssuer)
+	assert.NotErrorIs(t, err, ErrTokenMismatch)
+	assert.Contains(t, err.Error(), "pay-service")
+
+	// 过期
+	now := time.Now()
+	s.now = func() time.Time { return now.Add(time.Minute) }
+	_, err = s.Verify(payload + "." + sig)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestTokenSigner_KeyRotation(t *testing.T) {
+	s := newTestSigner(t)
+	oldToken := s.Sign("user-1", time.Minute)
+
+	s.AddKey("k2", []byte("secret-2"))
+	assert.NoError(t, s.UseKey("k2"))
+	newToken := s.Sign("user-1", time.Minute)
+
+	claims, err := s.Verify(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", claims.KeyID)
+	_, err = s.Verify(oldToken)
+	assert.NoError(t, err)
+
+	assert.Error(t, s.RemoveKey("k2"))
+	assert.NoError(t, s.RemoveKey("k1"))
+	_, err = s.Verify(oldToken)
+	assert.ErrorIs(t, err, ErrTokenKeyUnknown)
+
+	assert.ErrorIs(t, s.UseKey("k3"), ErrTokenKeyUnknown)
+	_, err = NewTokenSigner("order-service", "missing", nil)
+	assert.ErrorIs(t, err, ErrTokenKeyUnknown)
+}
+
+func TestTokenSigner_ConsumeBlocksReplay(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSigner(t)
+	store := NewMemoryStore()
+	token := GenerateToken(WithSigner(s, "user-1", time.Minute))
+
+	_, err := s.Consume(ctx, store, token, "user-2")
+	assert.ErrorIs(t, err, ErrTokenMismatch)
+
+	claims, err := s.Consume(ctx, store, token, "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+
+	_, err = s.Consume(ctx, store, token, "user-1")
+	assert.ErrorIs(t, err, ErrTokenConsumed)
+}
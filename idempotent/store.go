@@ -0,0 +1,46 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound key 不存在或已过期
+var ErrNotFound = errors.New("idempotent: key not found")
+
+// IdempotencyStore 幂等结果的存储后端
+// 所有实现都必须保证 SetNX、CompareAndSwap、CompareAndDelete 的原子性，这是“同一个请求只处理一次”的基础
+type IdempotencyStore interface {
+	// Get 读取 key 对应的值，不存在或已过期时返回 ErrNotFound
+	Get(ctx context.Context, key string) (string, error)
+	// SetNX key 不存在时写入并设置过期时间（ttl <= 0 表示永不过期），返回是否写入成功
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Delete 删除 key，key 不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// CompareAndSwap 当前值等于 old 时原子地替换为 value 并重新设置过期时间，返回是否替换成功
+	CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error)
+	// CompareAndDelete 当前值等于 old 时原子地删除 key，返回是否删除成功
+	CompareAndDelete(ctx context.Context, key, old string) (bool, error)
+}
+
+// SwapEntry 批量比较并替换中的一项
+type SwapEntry struct {
+	Key   string
+	Old   string
+	Value string
+}
+
+// BatchStore 支持批量操作的存储，ProcessBatch 用它减少与存储之间的往返；
+// 每一项仍然是原子的，但整批操作不是一个事务。未实现该接口的存储逐项调用 IdempotencyStore 的方法
+type BatchStore interface {
+	IdempotencyStore
+	// SetNXBatch 对每个 key 执行 SetNX，values 与 keys 一一对应，返回每一项是否写入成功
+	SetNXBatch(ctx context.Context, keys, values []string, ttl time.Duration) ([]bool, error)
+	// GetBatch 读取多个 key，结果中只包含存在的 key
+	GetBatch(ctx context.Context, keys []string) (map[string]string, error)
+	// CompareAndSwapBatch 对每一项执行 CompareAndSwap
+	CompareAndSwapBatch(ctx context.Context, entries []SwapEntry, ttl time.Duration) ([]bool, error)
+	// CompareAndDeleteBatch 对每一项执行 CompareAndDelete，忽略 entries 中的 Value
+	CompareAndDeleteBatch(ctx context.Context, entries []SwapEntry) ([]bool, error)
+}
@@ -0,0 +1,129 @@
+package idempotent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStoreContract 所有 IdempotencyStore 实现都应满足的行为
+func testStoreContract(t *testing.T, store IdempotencyStore) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "k1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ok, err := store.SetNX(ctx, "k1", "v1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = store.SetNX(ctx, "k1", "v2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	val, err := store.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	assert.NoError(t, store.Delete(ctx, "k1"))
+	assert.NoError(t, store.Delete(ctx, "k1"))
+	_, err = store.Get(ctx, "k1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStoreContract(t, NewMemoryStore())
+}
+
+func TestMemoryStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	_, _ = store.SetNX(ctx, "short", "v", time.Second)
+	_, _ = store.SetNX(ctx, "forever", "v", 0)
+
+	now = now.Add(time.Second)
+	_, err := store.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get(ctx, "forever")
+	assert.NoError(t, err)
+
+	// 过期后可以重新写入
+	ok, err := store.SetNX(ctx, "short", "v2", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotent.json")
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	testStoreContract(t, store)
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "idempotent.json")
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	_, err = store.SetNX(ctx, "request-1", "success result", time.Minute)
+	assert.NoError(t, err)
+
+	reopened, err := NewFileStore(path)
+	assert.NoError(t, err)
+	val, err := reopened.Get(ctx, "request-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "success result", val)
+
+	result, err := NewGuard(reopened).ProcessRequest("request-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "success result", result)
+}
+
+func TestFileStore_RollbackOnPersistError(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "data")
+	assert.NoError(t, os.Mkdir(dir, 0o755))
+	store, err := NewFileStore(filepath.Join(dir, "idempotent.json"))
+	assert.NoError(t, err)
+	_, err = store.SetNX(ctx, "k1", "v1", time.Minute)
+	assert.NoError(t, err)
+
+	// 删除目录后每次落盘都会失败
+	assert.NoError(t, os.RemoveAll(dir))
+
+	assert.Error(t, store.Delete(ctx, "k1"))
+	val, err := store.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	ok, err := store.CompareAndSwap(ctx, "k1", "v1", "v2", time.Minute)
+	assert.Error(t, err)
+	assert.False(t, ok)
+	val, err = store.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	ok, err = store.CompareAndDelete(ctx, "k1", "v1")
+	assert.Error(t, err)
+	assert.False(t, ok)
+	val, err = store.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", val)
+}
+
+func TestFileStore_NullFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotent.json")
+	assert.NoError(t, os.WriteFile(path, []byte("null"), 0o644))
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	testStoreContract(t, store)
+}
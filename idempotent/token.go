@@ -1,8 +1,9 @@
 package idempotent
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -10,6 +11,7 @@ import (
 )
 
 // 通过token 机制实现接口的幂等性 https://www.cnblogs.com/taojietaoge/p/15845948.html
+// redisClient 进程共享的 Redis 客户端，单机和哨兵模式下与 SharedRedis 是同一个，集群模式下为 nil
 var redisClient *redis.Client
 
 const (
@@ -17,54 +19,67 @@ const (
 	Password       = ""
 	DB             = 1
 	ExpirationTime = time.Minute
+	// WorkTimeout ProcessRequest 和 Work 处理一个请求的最长时间
+	WorkTimeout = time.Second * 2
 )
 
+// RedisInit 返回进程共享的 Redis 客户端（单机或哨兵模式），与 SharedRedis 是同一个客户端；
+// InitRedis 配置为集群模式时没有 *redis.Client，返回 nil，此时应改用 SharedRedis
 func RedisInit() *redis.Client {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     Addr,
-		Password: Password,
-		DB:       DB,
-	})
-	return redisClient
+	client, ok := SharedRedis().(*redis.Client)
+	if !ok {
+		log.Printf("idempotent: RedisInit does not support cluster mode, use SharedRedis")
+		return nil
+	}
+	return client
 }
 
-// GenerateToken 1.生成token
-func GenerateToken() string {
-	return uuid.New().String()
+// SharedRedis 返回进程共享的 Redis 客户端，支持单机、哨兵和集群模式，第一次调用时按 InitRedis 设置的配置创建，
+// 未调用 InitRedis 时使用 DefaultRedisConfig
+func SharedRedis() redis.UniversalClient {
+	sharedRedisMu.Lock()
+	defer sharedRedisMu.Unlock()
+	if sharedRedis == nil {
+		client, err := sharedRedisConfig.NewClient()
+		if err != nil {
+			// 配置在 InitRedis 中已经校验过，不会走到这里
+			panic(err)
+		}
+		setSharedRedisLocked(sharedRedisConfig, client)
+	}
+	return sharedRedis
 }
 
-// ProcessRequest 2.模拟请求
-func ProcessRequest(requestID string) (string, error) {
-	redisClient := RedisInit()
-	// 检查Redis中是否存在请求标识符
-	existingResult, err := redisClient.Get(requestID).Result()
-	if err == nil {
-		// 如果存在，直接返回之前的处理结果
-		return existingResult, nil
-	} else if err != redis.Nil {
-		// 处理其他Redis错误
-		return "", err
-	}
-	result := ""
-	// 处理请求逻辑...
-	result, err = Work(requestID)
-	if err != nil {
-		return "", err
+// GenerateToken 1.生成token，只生成不记录；需要服务端校验 token 时使用 TokenService.Issue
+// 传入 WithSigner 时生成无需存储即可校验的签名 token
+func GenerateToken(opts ...TokenOption) string {
+	var o tokenOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
-	// 将请求标识符存储到Redis，并设置过期时间
-	err = redisClient.SetNX(requestID, result, ExpirationTime).Err()
-	if err != nil {
-		// 处理存储状态错误
-		return "", err
+	if o.signer != nil {
+		return o.signer.Sign(o.subject, o.ttl)
 	}
-	return result, nil
+	return uuid.New().String()
 }
 
-// Work 3.模拟处理逻辑
+// ProcessRequest 2.模拟请求，使用 DefaultGuard（Redis 存储）
+func ProcessRequest(requestID string) (string, error) {
+	return DefaultGuard().ProcessRequest(requestID)
+}
+
+// Work 3.模拟处理逻辑，最多等待 2 秒
 func Work(requestID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), WorkTimeout)
+	defer cancel()
+	return WorkContext(ctx, requestID)
+}
+
+// WorkContext 同 Work，超时/取消由 ctx 控制
+func WorkContext(ctx context.Context, requestID string) (string, error) {
 	// 向第三方执行http请求逻辑，若超时，自动重试/记录日志
+	// 真实的调用使用 RetryClient：所有重试携带同一个幂等键，重试用尽后返回带有每次尝试记录的 RetryError
 	channel := make(chan struct{}, 1)
-	defer close(channel)
 	go func() {
 		// 模拟超时
 		// time.Sleep(time.Second * 3)
@@ -76,8 +91,8 @@ func Work(requestID string) (string, error) {
 	case <-channel:
 		fmt.Println("正常执行结束...")
 		return "success result", nil
-	case <-time.After(time.Second * 2):
+	case <-ctx.Done():
 		fmt.Println("超时...记录日志...")
-		return "", errors.New("timeout")
+		return "", ctx.Err()
 	}
 }
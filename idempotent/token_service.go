@@ -0,0 +1,191 @@
+package idempotent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrTokenUnknown  = errors.New("idempotent: unknown token")
+	ErrTokenExpired  = errors.New("idempotent: token expired")
+	ErrTokenConsumed = errors.New("idempotent: token already consumed")
+	ErrTokenMismatch = errors.New("idempotent: token issued for a different subject or endpoint")
+)
+
+const tokenKeyPrefix = "token:"
+
+// tokenRecord 服务端保存的 token 信息
+type tokenRecord struct {
+	Subject    string    `json:"subject"`
+	Endpoint   string    `json:"endpoint"`
+	ExpireAt   time.Time `json:"expireAt"`
+	ConsumedAt time.Time `json:"consumedAt,omitempty"`
+}
+
+// TokenServiceOption TokenService 的可选项
+type TokenServiceOption func(*TokenService)
+
+// WithTokenTTL token 的有效期，默认 ExpirationTime
+func WithTokenTTL(ttl time.Duration) TokenServiceOption {
+	return func(s *TokenService) {
+		s.ttl = ttl
+	}
+}
+
+// WithTokenRetention token 过期或被使用后记录继续保留的时长，默认与有效期相同
+// 保留期内可以区分“已过期/已使用”和“伪造”的 token，之后统一按 ErrTokenUnknown 处理
+func WithTokenRetention(retention time.Duration) TokenServiceOption {
+	return func(s *TokenService) {
+		s.retention = retention
+	}
+}
+
+// TokenService 服务端签发并校验 token（token 机制的第 1 步和第 2 步）：
+// token 与用户/会话和接口绑定，只能在有效期内使用一次
+type TokenService struct {
+	store     IdempotencyStore
+	ttl       time.Duration
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewTokenService 创建 token 服务，token 保存在 store 中
+func NewTokenService(store IdempotencyStore, opts ...TokenServiceOption) *TokenService {
+	s := &TokenService{
+		store: store,
+		ttl:   ExpirationTime,
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.retention <= 0 {
+		s.retention = s.ttl
+	}
+	return s
+}
+
+// Issue 为 subject（用户ID/会话ID）访问 endpoint 签发一个 token
+func (s *TokenService) Issue(ctx context.Context, subject, endpoint string) (string, time.Time, error) {
+	token := GenerateToken()
+	expireAt := s.now().Add(s.ttl)
+	data, err := json.Marshal(tokenRecord{Subject: subject, Endpoint: endpoint, ExpireAt: expireAt})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	ok, err := s.store.SetNX(ctx, tokenKeyPrefix+token, string(data), s.ttl+s.retention)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !ok {
+		// uuid 冲突的概率可以忽略，出现时说明存储被写入了异常数据
+		return "", time.Time{}, errors.New("idempotent: token collision")
+	}
+	return token, expireAt, nil
+}
+
+// Consume 校验并使用 token，同一个 token 只有第一次调用会成功；
+// 依次返回 ErrTokenUnknown、ErrTokenConsumed、ErrTokenMismatch、ErrTokenExpired 表示不同的失败原因
+func (s *TokenService) Consume(ctx context.Context, token, subject, endpoint string) error {
+	key := tokenKeyPrefix + token
+	raw, err := s.store.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return ErrTokenUnknown
+	}
+	if err != nil {
+		return err
+	}
+
+	var rec tokenRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return ErrTokenUnknown
+	}
+	switch {
+	case !rec.ConsumedAt.IsZero():
+		return ErrTokenConsumed
+	case rec.Subject != subject || rec.Endpoint != endpoint:
+		return ErrTokenMismatch
+	case !s.now().Before(rec.ExpireAt):
+		return ErrTokenExpired
+	}
+
+	rec.ConsumedAt = s.now()
+	consumed, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	// 比较并替换是原子操作（Redis 中为 Lua 脚本），并发使用同一个 token 只有一个能成功
+	ok, err := s.store.CompareAndSwap(ctx, key, raw, string(consumed), s.retention)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTokenConsumed
+	}
+	return nil
+}
+
+// tokenResponse 签发接口的响应
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NewTokenHandler token 签发接口：客户端在提交表单前调用，查询参数 endpoint 为将要提交的接口路径
+// subject 从请求中提取用户ID或会话ID，返回空字符串表示未登录
+func NewTokenHandler(s *TokenService, subject func(r *http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub := subject(r)
+		if sub == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		endpoint := r.URL.Query().Get("endpoint")
+		if endpoint == "" {
+			http.Error(w, "missing endpoint", http.StatusBadRequest)
+			return
+		}
+
+		token, expireAt, err := s.Issue(r.Context(), sub, endpoint)
+		if err != nil {
+			http.Error(w, "failed to issue token", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(tokenResponse{Token: token, ExpiresAt: expireAt})
+	}
+}
+
+// NewTokenMiddleware 校验 Idempotency-Key 请求头中的 token 并在首次使用时消耗掉，
+// token 必须签发给当前用户和当前请求路径；重复提交返回 409
+func NewTokenMiddleware(s *TokenService, subject func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(IdempotencyKeyHeader)
+			if token == "" {
+				http.Error(w, "missing "+IdempotencyKeyHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			err := s.Consume(r.Context(), token, subject(r), r.URL.Path)
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r)
+			case errors.Is(err, ErrTokenUnknown):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, ErrTokenExpired):
+				http.Error(w, err.Error(), http.StatusGone)
+			case errors.Is(err, ErrTokenConsumed):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, ErrTokenMismatch):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			default:
+				http.Error(w, "token store unavailable", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
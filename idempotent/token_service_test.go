@@ -0,0 +1,120 @@
+package idempotent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTokenService(now *time.Time) *TokenService {
+	store := NewMemoryStore()
+	store.now = func() time.Time { return *now }
+	s := NewTokenService(store, WithTokenTTL(time.Minute), WithTokenRetention(time.Hour))
+	s.now = store.now
+	return s
+}
+
+func TestTokenService_Consume(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := newTestTokenService(&now)
+
+	token, expireAt, err := s.Issue(ctx, "user-1", "/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), expireAt)
+
+	assert.ErrorIs(t, s.Consume(ctx, token, "user-2", "/orders"), ErrTokenMismatch)
+	assert.ErrorIs(t, s.Consume(ctx, token, "user-1", "/payments"), ErrTokenMismatch)
+	assert.NoError(t, s.Consume(ctx, token, "user-1", "/orders"))
+	assert.ErrorIs(t, s.Consume(ctx, token, "user-1", "/orders"), ErrTokenConsumed)
+
+	// 伪造的 token
+	assert.ErrorIs(t, s.Consume(ctx, GenerateToken(), "user-1", "/orders"), ErrTokenUnknown)
+
+	// 过期的 token
+	expired, _, err := s.Issue(ctx, "user-1", "/orders")
+	assert.NoError(t, err)
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, s.Consume(ctx, expired, "user-1", "/orders"), ErrTokenExpired)
+
+	// 超过保留期后无法再区分
+	now = now.Add(time.Hour)
+	assert.ErrorIs(t, s.Consume(ctx, expired, "user-1", "/orders"), ErrTokenUnknown)
+}
+
+func TestTokenService_ConcurrentConsume(t *testing.T) {
+	ctx := context.Background()
+	s := NewTokenService(NewMemoryStore())
+	token, _, err := s.Issue(ctx, "user-1", "/orders")
+	assert.NoError(t, err)
+
+	var succeeded, consumed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := s.Consume(ctx, token, "user-1", "/orders"); err {
+			case nil:
+				atomic.AddInt32(&succeeded, 1)
+			case ErrTokenConsumed:
+				atomic.AddInt32(&consumed, 1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), succeeded)
+	assert.Equal(t, int32(99), consumed)
+}
+
+func TestTokenHandlerAndMiddleware(t *testing.T) {
+	s := NewTokenService(NewMemoryStore())
+	subject := func(r *http.Request) string { return r.Header.Get("X-User-Id") }
+
+	mux := http.NewServeMux()
+	mux.Handle("/tokens", NewTokenHandler(s, subject))
+	var calls int32
+	mux.Handle("/orders", NewTokenMiddleware(s, subject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+	})))
+
+	do := func(method, path, user, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			req.Header.Set("X-User-Id", user)
+		}
+		if token != "" {
+			req.Header.Set(IdempotencyKeyHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/tokens?endpoint=/orders", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/tokens", "user-1", "").Code)
+
+	rec := do(http.MethodPost, "/tokens?endpoint=/orders", "user-1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp tokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.Token)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/orders", "user-1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/orders", "user-1", "forged").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/orders", "user-2", resp.Token).Code)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/orders", "user-1", resp.Token).Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/orders", "user-1", resp.Token).Code)
+	assert.Equal(t, int32(1), calls)
+}
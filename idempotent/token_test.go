@@ -1,45 +1,41 @@
 package idempotent
 
 import (
+	"context"
+	"errors"
 	"testing"
-	"time"
 
-	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
 )
 
-// MockRedisClient is a mock implementation of the Redis client.
-type MockRedisClient struct {
-	data map[string]string
-}
-
-func (m *MockRedisClient) Get(key string) *redis.StringCmd {
-	val, _ := m.data[key]
-	return redis.NewStringResult(val, redis.Nil)
-}
-
-func (m *MockRedisClient) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
-	_, exists := m.data[key]
-	m.data[key] = value.(string)
-	return redis.NewBoolResult(!exists, nil)
-}
-
 func TestProcessRequest(t *testing.T) {
-	// Mock the Redis client
-	mockRedisClient := &MockRedisClient{
-		data: make(map[string]string),
+	redisStore, _ := newMiniRedisStore(t)
+	stores := map[string]IdempotencyStore{
+		"memory": NewMemoryStore(),
+		// 进程内的 Redis 协议服务，代替真实的 Redis
+		"redis": redisStore,
 	}
-	// redisClient = mockRedisClient // Set the global redisClient variable to use the mock
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testProcessRequest(t, store)
+		})
+	}
+}
+
+func testProcessRequest(t *testing.T, store IdempotencyStore) {
+	guard := NewGuard(store)
+	ctx := context.Background()
 
-	// Test case for an existing result in Redis
-	t.Run("ExistingResultInRedis", func(t *testing.T) {
-		// Set up initial data in Redis
+	// Test case for an existing result in the store
+	t.Run("ExistingResultInStore", func(t *testing.T) {
+		// Set up initial data in the store
 		requestID := "existing_request"
 		expectedResult := "Existing result in Redis"
-		mockRedisClient.data[requestID] = expectedResult
+		_, err := store.SetNX(ctx, requestID, expectedResult, ExpirationTime)
+		assert.NoError(t, err)
 
 		// Call the function
-		result, err := ProcessRequest(requestID)
+		result, err := guard.ProcessRequest(requestID)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -52,12 +48,14 @@ func TestProcessRequest(t *testing.T) {
 		requestID := GenerateToken()
 
 		// Call the function
-		result, err := ProcessRequest(requestID)
+		result, err := guard.ProcessRequest(requestID)
 
 		// Assertions
 		assert.NoError(t, err)
 		assert.Equal(t, "success result", result)
-		assert.Equal(t, "success result", mockRedisClient.data[requestID])
+		stored, err := store.Get(ctx, requestID)
+		assert.NoError(t, err)
+		assert.Equal(t, record{State: StateDone, Result: "success result"}, decodeRecord(stored))
 	})
 
 	// Test case for timeout during processing
@@ -66,21 +64,18 @@ func TestProcessRequest(t *testing.T) {
 		requestID := GenerateToken()
 
 		// Mock the Work function to simulate a timeout
-		// originalWork := Work
-		// Work = func(requestID string) (string, error) {
-		// 	time.Sleep(time.Second * 3)
-		// 	return "", errors.New("timeout")
-		// }
-		// defer func() {
-		// 	// Restore the original Work function
-		// 	Work = originalWork
-		// }()
+		guard := NewGuard(store, WithWork(func(ctx context.Context, requestID string) (string, error) {
+			return "", errors.New("timeout")
+		}))
 
 		// Call the function
-		result, err := ProcessRequest(requestID)
+		result, err := guard.ProcessRequest(requestID)
 
 		// Assertions
 		assert.Error(t, err)
 		assert.Equal(t, "", result)
+		// 失败的请求不保存结果，允许客户端重试
+		_, err = store.Get(ctx, requestID)
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 }
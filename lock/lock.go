@@ -0,0 +1,253 @@
+// Package lock 分布式锁：持有者 token 防止误删他人的锁，看门狗自动续期，
+// 每次加锁得到单调递增的 fencing token，下游存储据此拒绝过期持有者的写入
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotAcquired 锁已被其他持有者占用
+	ErrNotAcquired = errors.New("lock: not acquired")
+	// ErrNotHeld 锁已过期或已被释放，当前调用者不再持有
+	ErrNotHeld = errors.New("lock: not held")
+	// ErrInvalidTTL 租约短于 1ms：Redis 的 PX 以毫秒为单位，不接受 0
+	ErrInvalidTTL = errors.New("lock: ttl must be at least 1ms")
+)
+
+const (
+	defaultRetryInterval = 50 * time.Millisecond
+	defaultKeyPrefix     = "lock:"
+)
+
+// Locker 分布式锁
+type Locker interface {
+	// TryLock 尝试获取 key 的锁，租约为 ttl（至少 1ms），已被占用时立即返回 ErrNotAcquired
+	TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+	// Lock 获取 key 的锁，已被占用时按重试间隔等待，直到获取成功或 ctx 结束
+	Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+}
+
+// backend 锁的存储实现，所有操作都必须是原子的
+type backend interface {
+	// acquire key 未被占用时以 token 占用并返回新的 fencing token
+	acquire(ctx context.Context, key, token string, ttl time.Duration) (fence int64, ok bool, err error)
+	// refresh 仍由 token 持有时重置租约
+	refresh(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	// release 仍由 token 持有时删除
+	release(ctx context.Context, key, token string) (bool, error)
+}
+
+// Option Locker 的可选项
+type Option func(*options)
+
+type options struct {
+	retryInterval time.Duration
+	watchdog      bool
+	keyPrefix     string
+}
+
+// WithRetryInterval Lock 等待锁时的重试间隔，默认 50ms
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.retryInterval = interval
+	}
+}
+
+// WithWatchdog 加锁成功后启动看门狗，每隔 ttl/3 续期一次直到 Unlock；
+// 续期失败导致租约过期时关闭 Lock.Lost 返回的 channel
+func WithWatchdog() Option {
+	return func(o *options) {
+		o.watchdog = true
+	}
+}
+
+// WithKeyPrefix 锁在存储中的 key 前缀，默认 lock:
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		retryInterval: defaultRetryInterval,
+		keyPrefix:     defaultKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// locker 基于 backend 实现 Locker
+type locker struct {
+	backend backend
+	opts    options
+}
+
+func (l *locker) TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+	token := uuid.New().String()
+	fence, ok, err := l.backend.acquire(ctx, l.opts.keyPrefix+key, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotAcquired
+	}
+
+	lk := &Lock{
+		backend: l.backend,
+		key:     l.opts.keyPrefix + key,
+		token:   token,
+		fence:   fence,
+		ttl:     ttl,
+		lost:    make(chan struct{}),
+	}
+	if l.opts.watchdog {
+		lk.startWatchdog()
+	}
+	return lk, nil
+}
+
+func (l *locker) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	for {
+		lk, err := l.TryLock(ctx, key, ttl)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lk, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.opts.retryInterval):
+		}
+	}
+}
+
+// Lock 已获取的锁
+type Lock struct {
+	backend backend
+	key     string
+	token   string
+	fence   int64
+	ttl     time.Duration
+
+	lostOnce sync.Once
+	lost     chan struct{}
+
+	// 看门狗
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// Key 加上前缀后的锁名；RedisLocker 在 Redis 中以 {Key} 保存锁（hash tag），fencing 计数器为 {Key}:fence
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token 持有者 token，只有持有者才能续期和释放
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Fence fencing token，同一个 key 每次加锁都比之前的大；
+// 写入下游时带上它，下游拒绝比已见过的更小的值，即可防止租约过期后仍在运行的旧持有者写入
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost 锁在 Unlock 之前丢失（看门狗续期失败）时关闭
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Refresh 将租约重置为 ttl（至少 1ms），锁已不再持有时返回 ErrNotHeld
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	ok, err := l.backend.refresh(ctx, l.key, l.token, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		l.markLost()
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Unlock 停止看门狗并释放锁，锁已过期或被其他持有者占用时返回 ErrNotHeld
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.stopWatchdog()
+	ok, err := l.backend.release(ctx, l.key, l.token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+	})
+}
+
+func (l *Lock) startWatchdog() {
+	l.stop = make(chan struct{})
+	l.stopped = make(chan struct{})
+	interval := l.ttl / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	go func() {
+		defer close(l.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := l.backend.refresh(context.Background(), l.key, l.token, l.ttl)
+			switch {
+			case err == nil && ok:
+				renewed = time.Now()
+			case err == nil:
+				// 锁已过期并可能被他人占用
+				l.markLost()
+				return
+			case time.Since(renewed) >= l.ttl:
+				// 存储一直不可用，租约已经过期
+				l.markLost()
+				return
+			}
+		}
+	}()
+}
+
+func (l *Lock) stopWatchdog() {
+	if l.stop == nil {
+		return
+	}
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.stopped
+}
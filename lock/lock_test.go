@@ -0,0 +1,241 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// lockerCase 同一组用例分别在 Redis 和内存实现上运行，advance 让锁的租约时间流逝
+type lockerCase struct {
+	name    string
+	locker  func(opts ...Option) Locker
+	advance func(d time.Duration)
+}
+
+func newLockerCases(t *testing.T) []lockerCase {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	var (
+		mu  sync.Mutex
+		now = time.Now()
+	)
+	return []lockerCase{
+		{
+			name:    "redis",
+			locker:  func(opts ...Option) Locker { return NewRedisLocker(client, opts...) },
+			advance: m.FastForward,
+		},
+		{
+			name: "memory",
+			locker: func(opts ...Option) Locker {
+				l := NewMemoryLocker(opts...)
+				l.backend.now = func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				}
+				return l
+			},
+			advance: func(d time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				now = now.Add(d)
+			},
+		},
+	}
+}
+
+func TestLocker_MutualExclusion(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range newLockerCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.locker()
+			first, err := l.TryLock(ctx, "order-1", time.Second)
+			assert.NoError(t, err)
+
+			_, err = l.TryLock(ctx, "order-1", time.Second)
+			assert.ErrorIs(t, err, ErrNotAcquired)
+
+			// 不同的 key 互不影响
+			other, err := l.TryLock(ctx, "order-2", time.Second)
+			assert.NoError(t, err)
+			assert.NoError(t, other.Unlock(ctx))
+
+			assert.NoError(t, first.Unlock(ctx))
+			assert.ErrorIs(t, first.Unlock(ctx), ErrNotHeld)
+
+			second, err := l.TryLock(ctx, "order-1", time.Second)
+			assert.NoError(t, err)
+			assert.Greater(t, second.Fence(), first.Fence())
+			assert.NoError(t, second.Unlock(ctx))
+		})
+	}
+}
+
+func TestLocker_ExpiredHolderCannotRelease(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range newLockerCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.locker()
+			stale, err := l.TryLock(ctx, "order-1", time.Second)
+			assert.NoError(t, err)
+
+			tc.advance(2 * time.Second)
+			current, err := l.TryLock(ctx, "order-1", time.Second)
+			assert.NoError(t, err)
+			assert.Greater(t, current.Fence(), stale.Fence())
+
+			// 过期的持有者既不能续期也不能释放别人的锁
+			assert.ErrorIs(t, stale.Refresh(ctx, time.Second), ErrNotHeld)
+			assert.ErrorIs(t, stale.Unlock(ctx), ErrNotHeld)
+			select {
+			case <-stale.Lost():
+			default:
+				t.Fatal("stale lock should be marked as lost")
+			}
+
+			_, err = l.TryLock(ctx, "order-1", time.Second)
+			assert.ErrorIs(t, err, ErrNotAcquired)
+			assert.NoError(t, current.Unlock(ctx))
+		})
+	}
+}
+
+func TestLocker_Refresh(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range newLockerCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.locker()
+			lk, err := l.TryLock(ctx, "order-1", time.Second)
+			assert.NoError(t, err)
+
+			tc.advance(800 * time.Millisecond)
+			assert.NoError(t, lk.Refresh(ctx, time.Second))
+			tc.advance(800 * time.Millisecond)
+
+			_, err = l.TryLock(ctx, "order-1", time.Second)
+			assert.ErrorIs(t, err, ErrNotAcquired)
+			assert.NoError(t, lk.Unlock(ctx))
+		})
+	}
+}
+
+func TestLocker_InvalidTTL(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range newLockerCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.locker()
+			for _, ttl := range []time.Duration{0, -time.Second, 500 * time.Microsecond} {
+				_, err := l.TryLock(ctx, "order-1", ttl)
+				assert.ErrorIs(t, err, ErrInvalidTTL)
+				_, err = l.Lock(ctx, "order-1", ttl)
+				assert.ErrorIs(t, err, ErrInvalidTTL)
+			}
+
+			lk, err := l.TryLock(ctx, "order-1", time.Millisecond)
+			assert.NoError(t, err)
+			assert.ErrorIs(t, lk.Refresh(ctx, 0), ErrInvalidTTL)
+			assert.Equal(t, "lock:order-1", lk.Key())
+		})
+	}
+}
+
+func TestLocker_LockWaits(t *testing.T) {
+	for _, tc := range newLockerCases(t) {
+		t.Run(tc.name, func(t *testing.T) {
+			l := tc.locker(WithRetryInterval(5 * time.Millisecond))
+			var holders, maxHolders, fence int64
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					lk, err := l.Lock(context.Background(), "order-1", time.Minute)
+					if !assert.NoError(t, err) {
+						return
+					}
+					n := atomic.AddInt64(&holders, 1)
+					if n > atomic.LoadInt64(&maxHolders) {
+						atomic.StoreInt64(&maxHolders, n)
+					}
+					// 持有锁期间 fencing token 单调递增
+					assert.Greater(t, lk.Fence(), atomic.LoadInt64(&fence))
+					atomic.StoreInt64(&fence, lk.Fence())
+					time.Sleep(time.Millisecond)
+					atomic.AddInt64(&holders, -1)
+					assert.NoError(t, lk.Unlock(context.Background()))
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int64(1), maxHolders)
+
+			// 等待超时
+			lk, err := l.TryLock(context.Background(), "order-2", time.Minute)
+			assert.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err = l.Lock(ctx, "order-2", time.Minute)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.NoError(t, lk.Unlock(context.Background()))
+		})
+	}
+}
+
+func TestRedisLocker_Watchdog(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer client.Close()
+	l := NewRedisLocker(client, WithWatchdog())
+
+	lk, err := l.TryLock(ctx, "order-1", 300*time.Millisecond)
+	assert.NoError(t, err)
+	lockKey, _ := redisKey(lk.Key())
+
+	// 看门狗每 100ms 续期一次，租约被重置
+	m.FastForward(250 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return m.TTL(lockKey) == 300*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, lk.Unlock(ctx))
+	assert.False(t, m.Exists(lockKey))
+
+	// 锁被删除后看门狗发现丢失
+	lk, err = l.TryLock(ctx, "order-1", 300*time.Millisecond)
+	assert.NoError(t, err)
+	m.Del(lockKey)
+	select {
+	case <-lk.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("watchdog should report the lock as lost")
+	}
+	assert.ErrorIs(t, lk.Unlock(ctx), ErrNotHeld)
+}
+
+func TestMemoryLocker_Watchdog(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLocker(WithWatchdog())
+	lk, err := l.TryLock(ctx, "order-1", 60*time.Millisecond)
+	assert.NoError(t, err)
+
+	// 超过多个租约周期仍然持有
+	time.Sleep(200 * time.Millisecond)
+	_, err = l.TryLock(ctx, "order-1", time.Second)
+	assert.ErrorIs(t, err, ErrNotAcquired)
+	select {
+	case <-lk.Lost():
+		t.Fatal("lock should not be lost")
+	default:
+	}
+	assert.NoError(t, lk.Unlock(ctx))
+}
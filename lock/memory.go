@@ -0,0 +1,82 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLocker 只在当前进程内互斥的锁，多个实例之间不互斥；
+// 租约、持有者校验和 fencing token 的语义与 RedisLocker 相同，测试中可以用它代替 RedisLocker
+type MemoryLocker struct {
+	locker
+	backend *memoryBackend
+}
+
+// NewMemoryLocker 创建内存锁
+func NewMemoryLocker(opts ...Option) *MemoryLocker {
+	b := &memoryBackend{
+		holders: make(map[string]memoryHolder),
+		fences:  make(map[string]int64),
+		now:     time.Now,
+	}
+	return &MemoryLocker{locker: locker{backend: b, opts: newOptions(opts)}, backend: b}
+}
+
+type memoryHolder struct {
+	token    string
+	expireAt time.Time
+}
+
+type memoryBackend struct {
+	mu      sync.Mutex
+	holders map[string]memoryHolder
+	fences  map[string]int64
+	now     func() time.Time
+}
+
+// heldLocked 返回 key 当前未过期的持有者
+func (b *memoryBackend) heldLocked(key string) (memoryHolder, bool) {
+	h, ok := b.holders[key]
+	if !ok {
+		return memoryHolder{}, false
+	}
+	if !b.now().Before(h.expireAt) {
+		delete(b.holders, key)
+		return memoryHolder{}, false
+	}
+	return h, true
+}
+
+func (b *memoryBackend) acquire(_ context.Context, key, token string, ttl time.Duration) (int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.heldLocked(key); ok {
+		return 0, false, nil
+	}
+	b.holders[key] = memoryHolder{token: token, expireAt: b.now().Add(ttl)}
+	b.fences[key]++
+	return b.fences[key], true, nil
+}
+
+func (b *memoryBackend) refresh(_ context.Context, key, token string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.heldLocked(key)
+	if !ok || h.token != token {
+		return false, nil
+	}
+	b.holders[key] = memoryHolder{token: token, expireAt: b.now().Add(ttl)}
+	return true, nil
+}
+
+func (b *memoryBackend) release(_ context.Context, key, token string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.heldLocked(key)
+	if !ok || h.token != token {
+		return false, nil
+	}
+	delete(b.holders, key)
+	return true, nil
+}
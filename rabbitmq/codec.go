@@ -0,0 +1,245 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/streadway/amqp"
+)
+
+// Compression 消息体压缩算法，通过 amqp.Publishing.ContentEncoding 告知消费端
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// 加密相关的消息头
+const (
+	headerEncryptionAlg        = "x-encryption-alg"
+	headerEncryptionKeyID      = "x-encryption-key-id"
+	headerEncryptionWrappedKey = "x-encryption-wrapped-key"
+
+	encryptionAlgAESGCM = "AES-GCM"
+	dataKeySize         = 32
+
+	// maxDecompressedSize 解压后消息体的上限，防止压缩炸弹耗尽消费端内存
+	maxDecompressedSize = 64 << 20
+)
+
+var (
+	ErrUnknownKeyID        = errors.New("rabbitmq: unknown encryption key id")
+	ErrUnsupportedEncoding = errors.New("rabbitmq: unsupported content encoding")
+	ErrMalformedEnvelope   = errors.New("rabbitmq: malformed encryption envelope")
+	ErrBodyTooLarge        = errors.New("rabbitmq: decompressed body too large")
+)
+
+// Keyring 主密钥（KEK）集合，key 为密钥ID，value 为 16/24/32 字节的 AES 密钥
+// 轮换密钥时新增一个 ID 即可，旧 ID 保留到存量消息消费完毕
+type Keyring map[string][]byte
+
+// encodedMessage 编码后的消息，对应 amqp.Publishing 中的同名字段
+type encodedMessage struct {
+	Body            []byte
+	ContentEncoding string
+	Headers         amqp.Table
+}
+
+// encodeBody 先压缩再加密（加密后的数据无法再被有效压缩）
+func encodeBody(body []byte, opts *publishOptions) (encodedMessage, error) {
+	msg := encodedMessage{Body: body}
+
+	if opts.compression != CompressionNone {
+		compressed, err := compress(opts.compression, body)
+		if err != nil {
+			return msg, err
+		}
+		msg.Body = compressed
+		msg.ContentEncoding = string(opts.compression)
+	}
+
+	if opts.keyID != "" {
+		kek, ok := opts.keyring[opts.keyID]
+		if !ok {
+			return msg, fmt.Errorf("%w: %s", ErrUnknownKeyID, opts.keyID)
+		}
+		sealed, wrappedKey, err := sealEnvelope(kek, opts.keyID, msg.Body)
+		if err != nil {
+			return msg, err
+		}
+		msg.Body = sealed
+		msg.Headers = amqp.Table{
+			headerEncryptionAlg:        encryptionAlgAESGCM,
+			headerEncryptionKeyID:      opts.keyID,
+			headerEncryptionWrappedKey: wrappedKey,
+		}
+	}
+
+	return msg, nil
+}
+
+// decodeBody encodeBody 的逆过程：先解密再解压
+func decodeBody(headers amqp.Table, contentEncoding string, body []byte, opts *consumeOptions) ([]byte, error) {
+	if keyID, ok := headers[headerEncryptionKeyID].(string); ok {
+		if alg, _ := headers[headerEncryptionAlg].(string); alg != encryptionAlgAESGCM {
+			return nil, fmt.Errorf("%w: alg %q", ErrMalformedEnvelope, alg)
+		}
+		wrappedKey, ok := headers[headerEncryptionWrappedKey].([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing wrapped key", ErrMalformedEnvelope)
+		}
+		kek, ok := opts.keyring[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+		}
+		plain, err := openEnvelope(kek, keyID, wrappedKey, body)
+		if err != nil {
+			return nil, err
+		}
+		body = plain
+	}
+
+	return decompress(Compression(contentEncoding), body)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdErr
+}
+
+func compress(c Compression, body []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(body, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, c)
+	}
+}
+
+func decompress(c Compression, body []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return body, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		plain, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(plain) > maxDecompressedSize {
+			return nil, ErrBodyTooLarge
+		}
+		return plain, nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		plain, err := zstdDecoder.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return nil, ErrBodyTooLarge
+		}
+		return plain, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, c)
+	}
+}
+
+// sealEnvelope 返回 nonce+密文 以及被主密钥加密后的数据密钥
+// 密钥ID 作为附加数据参与认证，防止消息头中的密钥ID被篡改
+func sealEnvelope(kek []byte, keyID string, plain []byte) (sealed, wrappedKey []byte, err error) {
+	dek := make([]byte, dataKeySize)
+	if _, err = rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+	if sealed, err = gcmSeal(dek, plain, []byte(keyID)); err != nil {
+		return nil, nil, err
+	}
+	if wrappedKey, err = gcmSeal(kek, dek, []byte(keyID)); err != nil {
+		return nil, nil, err
+	}
+	return sealed, wrappedKey, nil
+}
+
+func openEnvelope(kek []byte, keyID string, wrappedKey, sealed []byte) ([]byte, error) {
+	dek, err := gcmOpen(kek, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dek, sealed, []byte(keyID))
+}
+
+func gcmSeal(key, plain, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+func gcmOpen(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedEnvelope
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,94 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeBody(t *testing.T) {
+	keyring := Keyring{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	}
+	body := bytes.Repeat([]byte(`{"name":"RabbitMQ","pii":"13800000000"}`), 100)
+
+	cases := []struct {
+		name string
+		opts []PublishOption
+	}{
+		{"Plain", nil},
+		{"Gzip", []PublishOption{WithCompression(CompressionGzip)}},
+		{"Zstd", []PublishOption{WithCompression(CompressionZstd)}},
+		{"Encrypted", []PublishOption{WithEncryption(keyring, "k1")}},
+		{"ZstdEncrypted", []PublishOption{WithCompression(CompressionZstd), WithEncryption(keyring, "k2")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var pubOpts publishOptions
+			for _, opt := range c.opts {
+				opt(&pubOpts)
+			}
+			msg, err := encodeBody(body, &pubOpts)
+			assert.NoError(t, err)
+			assert.Equal(t, string(pubOpts.compression), msg.ContentEncoding)
+			if pubOpts.compression != CompressionNone {
+				assert.Less(t, len(msg.Body), len(body))
+			}
+			if pubOpts.keyID != "" {
+				assert.Equal(t, pubOpts.keyID, msg.Headers[headerEncryptionKeyID])
+				assert.False(t, bytes.Contains(msg.Body, []byte("13800000000")))
+			}
+
+			decoded, err := decodeBody(msg.Headers, msg.ContentEncoding, msg.Body, &consumeOptions{keyring: keyring})
+			assert.NoError(t, err)
+			assert.Equal(t, body, decoded)
+		})
+	}
+}
+
+func TestDecodeBody_Errors(t *testing.T) {
+	keyring := Keyring{"k1": bytes.Repeat([]byte{1}, 32)}
+	msg, err := encodeBody([]byte("secret"), &publishOptions{keyring: keyring, keyID: "k1"})
+	assert.NoError(t, err)
+
+	t.Run("UnknownKeyID", func(t *testing.T) {
+		_, err := decodeBody(msg.Headers, msg.ContentEncoding, msg.Body, &consumeOptions{})
+		assert.True(t, errors.Is(err, ErrUnknownKeyID))
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte(nil), msg.Body...)
+		tampered[len(tampered)-1] ^= 0xff
+		_, err := decodeBody(msg.Headers, msg.ContentEncoding, tampered, &consumeOptions{keyring: keyring})
+		assert.True(t, errors.Is(err, ErrMalformedEnvelope))
+	})
+
+	t.Run("UnsupportedEncoding", func(t *testing.T) {
+		_, err := decodeBody(nil, "br", []byte("data"), &consumeOptions{})
+		assert.True(t, errors.Is(err, ErrUnsupportedEncoding))
+	})
+
+	t.Run("PublishUnknownKeyID", func(t *testing.T) {
+		_, err := encodeBody([]byte("secret"), &publishOptions{keyring: keyring, keyID: "missing"})
+		assert.True(t, errors.Is(err, ErrUnknownKeyID))
+	})
+}
+
+func TestDecodeBody_DecompressionLimit(t *testing.T) {
+	// 压缩后很小，解压后超过上限
+	bomb := make([]byte, maxDecompressedSize+1)
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			msg, err := encodeBody(bomb, &publishOptions{compression: c})
+			assert.NoError(t, err)
+			assert.Less(t, len(msg.Body), 1<<20)
+
+			_, err = decodeBody(msg.Headers, msg.ContentEncoding, msg.Body, &consumeOptions{})
+			assert.True(t, errors.Is(err, ErrBodyTooLarge), "got %v", err)
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// SelectStrategy 多节点时选择 broker 的策略
+type SelectStrategy int
+
+const (
+	// RoundRobin 轮询：每次（重新）连接都从上一次使用节点的下一个开始尝试，分散连接
+	RoundRobin SelectStrategy = iota
+	// Priority 优先级：总是按 URL 列表顺序尝试，排在前面的节点优先
+	Priority
+)
+
+var (
+	ErrNoBrokerURLs      = errors.New("rabbitmq: no broker urls")
+	ErrAllBrokersDown    = errors.New("rabbitmq: all brokers unreachable")
+	ErrConnectionClosed  = errors.New("rabbitmq: connection manager closed")
+	defaultReconnectWait = 2 * time.Second
+)
+
+// amqpConnection *amqp.Connection 中连接管理器用到的方法，便于测试时替换
+type amqpConnection interface {
+	Channel() (*amqp.Channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// ConnectionOption 连接管理器可选项
+type ConnectionOption func(*ConnectionManager)
+
+// WithSelectStrategy 设置节点选择策略，默认 RoundRobin
+func WithSelectStrategy(strategy SelectStrategy) ConnectionOption {
+	return func(m *ConnectionManager) {
+		m.strategy = strategy
+	}
+}
+
+// WithReconnectWait 所有节点都连接失败后，等待多久再进行下一轮重连
+func WithReconnectWait(wait time.Duration) ConnectionOption {
+	return func(m *ConnectionManager) {
+		m.reconnectWait = wait
+	}
+}
+
+// WithReconnectHandler 每次故障转移成功后回调，参数为新连接的节点（已隐藏账号密码）
+func WithReconnectHandler(fn func(node string)) ConnectionOption {
+	return func(m *ConnectionManager) {
+		m.onReconnect = fn
+	}
+}
+
+// ConnectionManager 管理到 RabbitMQ 集群的连接
+// 连接建立失败或连接被 broker 异常关闭时，自动切换到下一个可用节点
+type ConnectionManager struct {
+	urls          []string
+	strategy      SelectStrategy
+	reconnectWait time.Duration
+	onReconnect   func(node string)
+	dial          func(url string) (amqpConnection, error)
+
+	// dialMu 保证同一时间只有一个协程在拨号；拨号可能要等所有节点超时，期间不持有 mu，
+	// CurrentNode、Close 等不会被阻塞
+	dialMu sync.Mutex
+
+	mu        sync.RWMutex
+	conn      amqpConnection
+	current   int           // 当前连接的节点下标，-1 表示未连接
+	cursor    int           // RoundRobin 下一次开始尝试的节点下标
+	connected chan struct{} // 每次连接成功后关闭并替换，用于唤醒等待重连的协程
+	closed    bool
+}
+
+// NewConnectionManager 创建连接管理器并立即建立连接
+func NewConnectionManager(urls []string, opts ...ConnectionOption) (*ConnectionManager, error) {
+	m, err := newConnectionManager(urls, func(url string) (amqpConnection, error) {
+		return amqp.Dial(url)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Connect(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newConnectionManager(urls []string, dial func(string) (amqpConnection, error),
+	opts ...ConnectionOption) (*ConnectionManager, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoBrokerURLs
+	}
+
+	m := &ConnectionManager{
+		urls:          urls,
+		strategy:      RoundRobin,
+		reconnectWait: defaultReconnectWait,
+		dial:          dial,
+		current:       -1,
+		connected:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+// Connect 按策略依次尝试各节点，直到有一个连接成功；已有连接会先被关闭
+func (m *ConnectionManager) Connect() error {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+	_, err := m.reconnect(conn)
+	return err
+}
+
+// reconnect 关闭 stale 并重新连接；当前连接已经不是 stale 时说明其他协程完成了重连，
+// 直接返回 false
+func (m *ConnectionManager) reconnect(stale amqpConnection) (bool, error) {
+	m.dialMu.Lock()
+	defer m.dialMu.Unlock()
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return false, ErrConnectionClosed
+	}
+	if m.conn != stale {
+		m.mu.Unlock()
+		return false, nil
+	}
+	m.conn = nil
+	m.current = -1
+	start := 0
+	if m.strategy == RoundRobin {
+		start = m.cursor
+	}
+	m.mu.Unlock()
+	if stale != nil {
+		_ = stale.Close()
+	}
+
+	var errs []error
+	for i := 0; i < len(m.urls); i++ {
+		idx := (start + i) % len(m.urls)
+		conn, err := m.dial(m.urls[idx])
+		if err != nil {
+			log.Printf("Failed to connect to RabbitMQ node %s: %v", redactURL(m.urls[idx]), err)
+			errs = append(errs, fmt.Errorf("%s: %w", redactURL(m.urls[idx]), err))
+			continue
+		}
+
+		m.mu.Lock()
+		if m.closed {
+			// 拨号期间管理器被关闭
+			m.mu.Unlock()
+			_ = conn.Close()
+			return false, ErrConnectionClosed
+		}
+		m.conn = conn
+		m.current = idx
+		m.cursor = (idx + 1) % len(m.urls)
+		close(m.connected)
+		m.connected = make(chan struct{})
+		// 同步注册关闭通知，避免注册前连接就已断开而漏掉事件
+		go m.watch(conn, conn.NotifyClose(make(chan *amqp.Error, 1)))
+		m.mu.Unlock()
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %v", ErrAllBrokersDown, errors.Join(errs...))
+}
+
+// watch 监听连接关闭事件，异常关闭时触发故障转移
+func (m *ConnectionManager) watch(conn amqpConnection, closeCh chan *amqp.Error) {
+	amqpErr, ok := <-closeCh
+	if !ok || amqpErr == nil {
+		// 正常关闭（调用了 Close）不需要重连
+		return
+	}
+	log.Printf("RabbitMQ connection to %s closed: %v, failing over", m.CurrentNode(), amqpErr)
+	m.failover(conn)
+}
+
+// failover 丢弃失效的连接并重连，直到成功或管理器被关闭
+func (m *ConnectionManager) failover(failed amqpConnection) {
+	for {
+		reconnected, err := m.reconnect(failed)
+		if err == nil {
+			if !reconnected {
+				// 其他协程已经完成了故障转移
+				return
+			}
+			node := m.CurrentNode()
+			log.Printf("RabbitMQ failed over to %s", node)
+			if m.onReconnect != nil {
+				m.onReconnect(node)
+			}
+			return
+		}
+		if errors.Is(err, ErrConnectionClosed) {
+			return
+		}
+		log.Printf("RabbitMQ reconnect failed: %v, retry in %s", err, m.reconnectWait)
+		time.Sleep(m.reconnectWait)
+		// 上一轮全部失败后当前连接为 nil
+		failed = nil
+	}
+}
+
+// Channel 在当前连接上打开一个新的 channel，打开失败时切换节点后再试一次
+func (m *ConnectionManager) Channel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	conn, closed := m.conn, m.closed
+	m.mu.RUnlock()
+	if closed {
+		return nil, ErrConnectionClosed
+	}
+
+	if conn != nil {
+		ch, err := conn.Channel()
+		if err == nil {
+			return ch, nil
+		}
+		log.Printf("Failed to open a channel on %s: %v", m.CurrentNode(), err)
+	}
+
+	if _, err := m.reconnect(conn); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	conn = m.conn
+	m.mu.RUnlock()
+	if conn == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	return conn.Channel()
+}
+
+// ReopenChannel 打开一个新的 channel，用于替换随连接一起失效的 channel（故障转移不会恢复已打开的 channel）。
+// 打开失败时等待故障转移完成或 reconnectWait 后重试，直到成功或管理器被关闭
+func (m *ConnectionManager) ReopenChannel() (*amqp.Channel, error) {
+	for {
+		m.mu.RLock()
+		connected := m.connected
+		m.mu.RUnlock()
+
+		ch, err := m.Channel()
+		if err == nil || errors.Is(err, ErrConnectionClosed) {
+			return ch, err
+		}
+		log.Printf("Failed to reopen a channel: %v, retry in %s", err, m.reconnectWait)
+		select {
+		case <-connected:
+		case <-time.After(m.reconnectWait):
+		}
+	}
+}
+
+// isConnectionError err 是否因为 channel 或连接失效（包括故障转移），通过 ReopenChannel 换一个新的 channel 后可以重试；
+// 其他错误（如消息编码失败）重试也不会成功
+func isConnectionError(err error) bool {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		return false
+	}
+	switch amqpErr.Code {
+	case amqp.ChannelError, amqp.ConnectionForced:
+		return true
+	}
+	return false
+}
+
+// CurrentNode 返回当前连接的节点（host:port），未连接时返回空字符串
+func (m *ConnectionManager) CurrentNode() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.currentNodeLocked()
+}
+
+func (m *ConnectionManager) currentNodeLocked() string {
+	if m.current < 0 {
+		return ""
+	}
+	return redactURL(m.urls[m.current])
+}
+
+// Close 关闭连接，之后不再自动重连
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.current = -1
+	if m.conn == nil {
+		return nil
+	}
+	conn := m.conn
+	m.conn = nil
+	return conn.Close()
+}
+
+// redactURL 去掉 URL 中的账号密码，只保留 host:port，避免打印到日志中
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -1,17 +1,57 @@
 package rabbitmq
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"time"
 )
 
+const (
+	// consumeRetryInitial 注册消费者失败后第一次重试前的等待时间
+	consumeRetryInitial = time.Second
+	// consumeRetryMax 注册消费者连续失败时等待时间的上限
+	consumeRetryMax = 30 * time.Second
+)
+
+// nextConsumeBackoff 注册消费者连续失败时下一次的等待时间，从 consumeRetryInitial 开始每次翻倍，不超过 consumeRetryMax
+func nextConsumeBackoff(prev time.Duration) time.Duration {
+	if prev <= 0 {
+		return consumeRetryInitial
+	}
+	if next := prev * 2; next < consumeRetryMax {
+		return next
+	}
+	return consumeRetryMax
+}
+
 func Consume() {
 	fmt.Println("Consume Start....")
 
-	ch, conn := CreateConnAndChannel()
-	defer conn.Close()
-	defer ch.Close()
+	manager, err := NewConnectionManager(rabbitMQURLs)
+	failOnError(err, "Failed to connect to RabbitMQ")
+	defer manager.Close()
+	fmt.Println("Connected to", manager.CurrentNode())
+
+	// 故障转移只恢复连接，channel 和消费者随旧连接一起失效，需要在新连接上重新注册
+	var backoff time.Duration
+	for {
+		ch, err := manager.ReopenChannel()
+		if errors.Is(err, ErrConnectionClosed) {
+			return
+		}
+		failOnError(err, "Failed to open a channel")
 
-	// DeclareQueueAndExchange(ch)
-	ConsumeMessagesWithAck(ch)
-	select {}
+		err = ConsumeMessagesWithAck(ch)
+		_ = ch.Close()
+		if err == nil {
+			backoff = 0
+			log.Printf("Consumer on %s stopped, re-registering", manager.CurrentNode())
+			continue
+		}
+		// 队列不存在等错误不会很快恢复，退避后再重试，避免空转刷屏
+		backoff = nextConsumeBackoff(backoff)
+		log.Printf("Failed to consume: %v, retry in %s", err, backoff)
+		time.Sleep(backoff)
+	}
 }
@@ -0,0 +1,21 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextConsumeBackoff(t *testing.T) {
+	var backoff time.Duration
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		backoff = nextConsumeBackoff(backoff)
+		got = append(got, backoff)
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		consumeRetryMax, consumeRetryMax,
+	}, got)
+}
@@ -0,0 +1,195 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeManagementAPI 模拟管理插件的 /api/queues/{vhost}/{name} 接口
+type fakeManagementAPI struct {
+	mu     sync.Mutex
+	stats  QueueStats
+	status int
+}
+
+func (f *fakeManagementAPI) set(fn func(s *QueueStats)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.stats)
+}
+
+func (f *fakeManagementAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "guest" || pass != "guest" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.EscapedPath() != "/api/queues/%2F/"+queueName && r.URL.EscapedPath() != "/api/queues/staging/"+queueName {
+		http.NotFound(w, r)
+		return
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(f.stats)
+}
+
+func TestManagementClient_QueueStats(t *testing.T) {
+	api := &fakeManagementAPI{}
+	api.set(func(s *QueueStats) {
+		s.Name = queueName
+		s.Messages = 120
+		s.MessagesUnacknowledged = 20
+		s.Consumers = 2
+		s.MessageStats.PublishDetails.Rate = 50
+		s.MessageStats.DeliverGetDetails.Rate = 45.5
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client := NewManagementClient(server.URL+"/", "guest", "guest")
+	stats, err := client.QueueStats(context.Background(), defaultVhost, queueName)
+	assert.NoError(t, err)
+	assert.Equal(t, 120, stats.Messages)
+	assert.Equal(t, 2, stats.Consumers)
+	assert.Equal(t, 50.0, stats.PublishRate())
+	assert.Equal(t, 45.5, stats.DeliverRate())
+
+	_, err = client.QueueStats(context.Background(), defaultVhost, "missing")
+	assert.Error(t, err)
+	_, err = NewManagementClient(server.URL, "guest", "wrong").QueueStats(context.Background(), defaultVhost, queueName)
+	assert.Error(t, err)
+}
+
+func TestQueueMonitor_Alerts(t *testing.T) {
+	api := &fakeManagementAPI{}
+	api.set(func(s *QueueStats) {
+		s.Messages = 10
+		s.Consumers = 1
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	var alerts []Alert
+	monitor := NewQueueMonitor(NewManagementClient(server.URL, "guest", "guest"), time.Second,
+		func(alert Alert) { alerts = append(alerts, alert) },
+		MonitoredQueue{
+			Vhost: defaultVhost,
+			Name:  queueName,
+			Thresholds: QueueThresholds{
+				MaxMessages:  100,
+				MinConsumers: 1,
+				MaxLagRate:   10,
+			},
+		})
+	ctx := context.Background()
+
+	// 一切正常，不告警
+	monitor.Check(ctx)
+	assert.Empty(t, alerts)
+
+	// 消费者全部下线，消息开始堆积
+	api.set(func(s *QueueStats) {
+		s.Messages = 500
+		s.Consumers = 0
+		s.MessageStats.PublishDetails.Rate = 30
+	})
+	monitor.Check(ctx)
+	if assert.Len(t, alerts, 3) {
+		assert.Equal(t, AlertQueueDepth, alerts[0].Kind)
+		assert.Equal(t, 500.0, alerts[0].Value)
+		assert.Equal(t, AlertConsumers, alerts[1].Kind)
+		assert.Equal(t, AlertConsumerLag, alerts[2].Kind)
+		assert.Equal(t, 30.0, alerts[2].Value)
+		assert.False(t, alerts[2].Resolved)
+	}
+
+	// 状态未变化，不重复告警
+	monitor.Check(ctx)
+	assert.Len(t, alerts, 3)
+
+	// 管理 API 不可用
+	api.mu.Lock()
+	api.status = http.StatusServiceUnavailable
+	api.mu.Unlock()
+	monitor.Check(ctx)
+	if assert.Len(t, alerts, 4) {
+		assert.Equal(t, AlertUnavailable, alerts[3].Kind)
+		assert.Error(t, alerts[3].Err)
+	}
+
+	// 恢复正常，逐项发出恢复事件
+	api.mu.Lock()
+	api.status = 0
+	api.mu.Unlock()
+	api.set(func(s *QueueStats) {
+		s.Messages = 0
+		s.Consumers = 3
+		s.MessageStats.DeliverGetDetails.Rate = 40
+	})
+	monitor.Check(ctx)
+	if assert.Len(t, alerts, 8) {
+		for _, alert := range alerts[4:] {
+			assert.True(t, alert.Resolved, alert.String())
+		}
+	}
+}
+
+func TestQueueMonitor_AlertsPerVhost(t *testing.T) {
+	api := &fakeManagementAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	// 两个 vhost 下的同名队列，告警状态互不影响
+	var alerts []Alert
+	thresholds := QueueThresholds{MinConsumers: 1}
+	monitor := NewQueueMonitor(NewManagementClient(server.URL, "guest", "guest"), time.Second,
+		func(alert Alert) { alerts = append(alerts, alert) },
+		MonitoredQueue{Vhost: defaultVhost, Name: queueName, Thresholds: thresholds},
+		MonitoredQueue{Vhost: "staging", Name: queueName, Thresholds: thresholds})
+
+	monitor.Check(context.Background())
+	if assert.Len(t, alerts, 2) {
+		assert.Equal(t, defaultVhost, alerts[0].Vhost)
+		assert.Equal(t, "staging", alerts[1].Vhost)
+		for _, alert := range alerts {
+			assert.Equal(t, AlertConsumers, alert.Kind)
+			assert.False(t, alert.Resolved)
+		}
+	}
+}
+
+func TestQueueMonitor_RunStopsOnCancel(t *testing.T) {
+	api := &fakeManagementAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var alerts []Alert
+	monitor := NewQueueMonitor(NewManagementClient(server.URL, "guest", "guest"), 10*time.Millisecond,
+		func(alert Alert) {
+			mu.Lock()
+			alerts = append(alerts, alert)
+			mu.Unlock()
+		},
+		MonitoredQueue{Vhost: defaultVhost, Name: queueName, Thresholds: QueueThresholds{MinConsumers: 1}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	monitor.Run(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, AlertConsumers, alerts[0].Kind)
+}
@@ -0,0 +1,266 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// memoryChannel 内存版的 broker + channel，实现 Channel 接口，供测试代替真实的 RabbitMQ
+type memoryChannel struct {
+	mu          sync.Mutex
+	exchanges   map[string]string // 交换机名 -> 类型
+	bindings    map[string][]memoryBinding
+	queues      map[string]*memoryQueue
+	confirming  bool
+	confirms    []chan amqp.Confirmation
+	returns     []chan amqp.Return
+	publishSeq  uint64
+	deliveryTag uint64
+	unacked     map[uint64]memoryUnacked
+	acked       []uint64
+	nacked      []uint64
+	closed      chan struct{}
+}
+
+type memoryBinding struct {
+	queue string
+	key   string
+}
+
+type memoryQueue struct {
+	maxPriority uint8
+	messages    []amqp.Publishing
+}
+
+type memoryUnacked struct {
+	queue string
+	msg   amqp.Publishing
+}
+
+func newMemoryChannel() *memoryChannel {
+	return &memoryChannel{
+		exchanges: make(map[string]string),
+		bindings:  make(map[string][]memoryBinding),
+		queues:    make(map[string]*memoryQueue),
+		unacked:   make(map[uint64]memoryUnacked),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (c *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges[name] = kind
+	return nil
+}
+
+func (c *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, ok := c.queues[name]
+	if !ok {
+		q = &memoryQueue{}
+		c.queues[name] = q
+	}
+	if v, ok := args["x-max-priority"]; ok {
+		// 与 broker 一致：只接受 1~255 的整数
+		p, ok := v.(int32)
+		if !ok {
+			return amqp.Queue{}, fmt.Errorf("x-max-priority must be int32, got %T", v)
+		}
+		if p < 1 || p > 255 {
+			return amqp.Queue{}, fmt.Errorf("x-max-priority must be between 1 and 255, got %d", p)
+		}
+		q.maxPriority = uint8(p)
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages)}, nil
+}
+
+func (c *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.exchanges[exchange]; !ok {
+		return fmt.Errorf("no exchange %q", exchange)
+	}
+	c.bindings[exchange] = append(c.bindings[exchange], memoryBinding{queue: name, key: key})
+	return nil
+}
+
+func (c *memoryChannel) Confirm(noWait bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirming = true
+	return nil
+}
+
+func (c *memoryChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = append(c.confirms, confirm)
+	return confirm
+}
+
+func (c *memoryChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.returns = append(c.returns, returns)
+	return returns
+}
+
+func (c *memoryChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var targets []string
+	if exchange == "" {
+		targets = []string{key}
+	} else {
+		kind, ok := c.exchanges[exchange]
+		if !ok {
+			return fmt.Errorf("no exchange %q", exchange)
+		}
+		for _, b := range c.bindings[exchange] {
+			if kind == amqp.ExchangeFanout || b.key == key {
+				targets = append(targets, b.queue)
+			}
+		}
+	}
+	routed := false
+	for _, name := range targets {
+		if q, ok := c.queues[name]; ok {
+			q.push(msg)
+			routed = true
+		}
+	}
+	if !routed && mandatory {
+		for _, ch := range c.returns {
+			ch <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key}
+		}
+	}
+
+	if c.confirming {
+		c.publishSeq++
+		for _, ch := range c.confirms {
+			ch <- amqp.Confirmation{DeliveryTag: c.publishSeq, Ack: true}
+		}
+	}
+	return nil
+}
+
+// push 按优先级插入：高优先级在前，同优先级保持先进先出
+func (q *memoryQueue) push(msg amqp.Publishing) {
+	if q.maxPriority == 0 {
+		q.messages = append(q.messages, msg)
+		return
+	}
+	if msg.Priority > q.maxPriority {
+		msg.Priority = q.maxPriority
+	}
+	i := len(q.messages)
+	for i > 0 && q.messages[i-1].Priority < msg.Priority {
+		i--
+	}
+	q.messages = append(q.messages, amqp.Publishing{})
+	copy(q.messages[i+1:], q.messages[i:])
+	q.messages[i] = msg
+}
+
+// get 从队列头部取出一条消息，类似 basic.get
+func (c *memoryChannel) get(queue string) (amqp.Delivery, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, ok := c.queues[queue]
+	if !ok || len(q.messages) == 0 {
+		return amqp.Delivery{}, false
+	}
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+
+	c.deliveryTag++
+	c.unacked[c.deliveryTag] = memoryUnacked{queue: queue, msg: msg}
+	return amqp.Delivery{
+		Acknowledger:    c,
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		DeliveryTag:     c.deliveryTag,
+		RoutingKey:      queue,
+		Body:            msg.Body,
+	}, true
+}
+
+func (c *memoryChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	_, ok := c.queues[queue]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no queue %q", queue)
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			d, ok := c.get(queue)
+			if !ok {
+				select {
+				case <-c.closed:
+					return
+				case <-time.After(time.Millisecond):
+					continue
+				}
+			}
+			select {
+			case deliveries <- d:
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// depth 返回队列中尚未投递的消息
+func (c *memoryChannel) depth(queue string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if q, ok := c.queues[queue]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+func (c *memoryChannel) Close() {
+	close(c.closed)
+}
+
+func (c *memoryChannel) Ack(tag uint64, multiple bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.unacked, tag)
+	c.acked = append(c.acked, tag)
+	return nil
+}
+
+func (c *memoryChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, ok := c.unacked[tag]; ok && requeue {
+		c.queues[u.queue].push(u.msg)
+	}
+	delete(c.unacked, tag)
+	c.nacked = append(c.nacked, tag)
+	return nil
+}
+
+func (c *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
@@ -0,0 +1,179 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+const (
+	managementURL = "http://114.132.210.241:15672"
+	defaultVhost  = "/"
+)
+
+// AlertKind 告警类型
+type AlertKind string
+
+const (
+	AlertQueueDepth  AlertKind = "queue_depth"  // 队列堆积超过阈值
+	AlertUnacked     AlertKind = "unacked"      // 已投递未确认的消息超过阈值
+	AlertConsumers   AlertKind = "consumers"    // 消费者数量低于阈值
+	AlertConsumerLag AlertKind = "consumer_lag" // 发布速率持续高于投递速率
+	AlertUnavailable AlertKind = "unavailable"  // 管理 API 查询失败
+)
+
+// QueueThresholds 队列告警阈值，为 0 表示不检查该项
+type QueueThresholds struct {
+	MaxMessages  int     // messages（ready + unacked）上限
+	MaxUnacked   int     // messages_unacknowledged 上限
+	MinConsumers int     // consumers 下限
+	MaxLagRate   float64 // 发布速率 - 投递速率 的上限（条/秒）
+}
+
+// MonitoredQueue 被监控的队列
+type MonitoredQueue struct {
+	Vhost      string
+	Name       string
+	Thresholds QueueThresholds
+}
+
+// Alert 告警事件；指标恢复正常时会再发送一次 Resolved 为 true 的事件
+type Alert struct {
+	Vhost     string
+	Queue     string
+	Kind      AlertKind
+	Value     float64
+	Threshold float64
+	Resolved  bool
+	Stats     *QueueStats // 查询失败时为 nil
+	Err       error       // 仅 AlertUnavailable 时有值
+	Time      time.Time
+}
+
+func (a Alert) String() string {
+	state := "FIRING"
+	if a.Resolved {
+		state = "RESOLVED"
+	}
+	if a.Err != nil {
+		return fmt.Sprintf("[%s] %s (vhost %s) %s: %v", state, a.Queue, a.Vhost, a.Kind, a.Err)
+	}
+	return fmt.Sprintf("[%s] %s (vhost %s) %s: value=%.2f threshold=%.2f", state, a.Queue, a.Vhost, a.Kind, a.Value, a.Threshold)
+}
+
+// QueueMonitor 定时轮询管理 API，指标越过阈值时通过回调发出告警
+// 同一队列的同一类告警只在状态变化（触发/恢复）时回调一次，避免重复告警
+type QueueMonitor struct {
+	client   *ManagementClient
+	interval time.Duration
+	queues   []MonitoredQueue
+	onAlert  func(Alert)
+
+	mu     sync.Mutex
+	firing map[string]bool // vhost + queue + kind -> 是否处于告警状态，不同 vhost 下的同名队列分别记录
+}
+
+// NewQueueMonitor 创建队列监控
+func NewQueueMonitor(client *ManagementClient, interval time.Duration, onAlert func(Alert), queues ...MonitoredQueue) *QueueMonitor {
+	return &QueueMonitor{
+		client:   client,
+		interval: interval,
+		queues:   queues,
+		onAlert:  onAlert,
+		firing:   make(map[string]bool),
+	}
+}
+
+// Run 按 interval 轮询，直到 ctx 被取消
+func (m *QueueMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.Check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Check 立即检查一次所有队列
+func (m *QueueMonitor) Check(ctx context.Context) {
+	for _, q := range m.queues {
+		stats, err := m.client.QueueStats(ctx, q.Vhost, q.Name)
+		if ctx.Err() != nil {
+			// 监控被停止，不是管理 API 的问题
+			return
+		}
+		now := time.Now()
+		m.update(Alert{Vhost: q.Vhost, Queue: q.Name, Kind: AlertUnavailable, Err: err, Time: now}, err != nil)
+		if err != nil {
+			continue
+		}
+
+		t := q.Thresholds
+		if t.MaxMessages > 0 {
+			m.update(Alert{Vhost: q.Vhost, Queue: q.Name, Kind: AlertQueueDepth, Value: float64(stats.Messages),
+				Threshold: float64(t.MaxMessages), Stats: stats, Time: now}, stats.Messages > t.MaxMessages)
+		}
+		if t.MaxUnacked > 0 {
+			m.update(Alert{Vhost: q.Vhost, Queue: q.Name, Kind: AlertUnacked, Value: float64(stats.MessagesUnacknowledged),
+				Threshold: float64(t.MaxUnacked), Stats: stats, Time: now}, stats.MessagesUnacknowledged > t.MaxUnacked)
+		}
+		if t.MinConsumers > 0 {
+			m.update(Alert{Vhost: q.Vhost, Queue: q.Name, Kind: AlertConsumers, Value: float64(stats.Consumers),
+				Threshold: float64(t.MinConsumers), Stats: stats, Time: now}, stats.Consumers < t.MinConsumers)
+		}
+		if t.MaxLagRate > 0 {
+			lag := stats.PublishRate() - stats.DeliverRate()
+			m.update(Alert{Vhost: q.Vhost, Queue: q.Name, Kind: AlertConsumerLag, Value: lag,
+				Threshold: t.MaxLagRate, Stats: stats, Time: now}, lag > t.MaxLagRate)
+		}
+	}
+}
+
+// update 记录告警状态，状态发生变化时回调
+func (m *QueueMonitor) update(alert Alert, breached bool) {
+	// vhost 和队列名中都可能出现 "/"，用 NUL 分隔避免不同组合拼出同一个 key
+	key := alert.Vhost + "\x00" + alert.Queue + "\x00" + string(alert.Kind)
+
+	m.mu.Lock()
+	changed := m.firing[key] != breached
+	m.firing[key] = breached
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	alert.Resolved = !breached
+	m.onAlert(alert)
+}
+
+// Monitor 监控 example_queue 的堆积和消费情况，告警打印到日志
+func Monitor() {
+	fmt.Println("Monitor Start....")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	client := NewManagementClient(managementURL, "guest", "guest")
+	monitor := NewQueueMonitor(client, 10*time.Second, func(alert Alert) {
+		log.Println(alert)
+	}, MonitoredQueue{
+		Vhost: defaultVhost,
+		Name:  queueName,
+		Thresholds: QueueThresholds{
+			MaxMessages:  10000,
+			MinConsumers: 1,
+			MaxLagRate:   100,
+		},
+	})
+	monitor.Run(ctx)
+}
@@ -0,0 +1,98 @@
+package rabbitmq
+
+import "github.com/streadway/amqp"
+
+// PublishOption 发布消息时的可选项
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	compression Compression
+	keyring     Keyring
+	keyID       string
+	messageType string
+	schemas     *SchemaRegistry
+	priority    uint8
+}
+
+// WithCompression 发布前压缩消息体，压缩算法写入 ContentEncoding
+func WithCompression(c Compression) PublishOption {
+	return func(o *publishOptions) {
+		o.compression = c
+	}
+}
+
+// WithEncryption 使用信封加密：每条消息随机生成数据密钥（DEK）加密消息体，
+// 再用 keyring[keyID] 加密 DEK，密钥ID 和加密后的 DEK 放在消息头中
+func WithEncryption(keyring Keyring, keyID string) PublishOption {
+	return func(o *publishOptions) {
+		o.keyring = keyring
+		o.keyID = keyID
+	}
+}
+
+// WithMessageType 设置消息类型（amqp.Publishing.Type），消费端据此查找消息格式
+func WithMessageType(messageType string) PublishOption {
+	return func(o *publishOptions) {
+		o.messageType = messageType
+	}
+}
+
+// ValidateOnPublish 发布前按消息类型校验消息体，不合法的消息不会被发送
+func ValidateOnPublish(schemas *SchemaRegistry) PublishOption {
+	return func(o *publishOptions) {
+		o.schemas = schemas
+	}
+}
+
+// WithPriority 设置消息优先级（amqp.Publishing.Priority），数值越大越先被投递
+// 只对声明了 x-max-priority 的队列生效，超过队列最大优先级的按最大优先级处理
+func WithPriority(priority uint8) PublishOption {
+	return func(o *publishOptions) {
+		o.priority = priority
+	}
+}
+
+// ConsumeOption 消费消息时的可选项
+type ConsumeOption func(*consumeOptions)
+
+type consumeOptions struct {
+	keyring Keyring
+	schemas *SchemaRegistry
+}
+
+// WithDecryptionKeys 指定解密消息所用的主密钥集合
+func WithDecryptionKeys(keyring Keyring) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.keyring = keyring
+	}
+}
+
+// ValidateOnConsume 处理前按消息类型校验消息体，不合法的消息直接投递到死信队列
+func ValidateOnConsume(schemas *SchemaRegistry) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.schemas = schemas
+	}
+}
+
+// QueueOption 声明队列时的可选项
+type QueueOption func(*queueOptions)
+
+type queueOptions struct {
+	maxPriority uint8
+}
+
+// WithMaxPriority 将队列声明为优先级队列（x-max-priority），RabbitMQ 建议不超过 10
+// 注意：队列参数不能修改，已存在的普通队列需要先删除才能重新声明为优先级队列
+func WithMaxPriority(maxPriority uint8) QueueOption {
+	return func(o *queueOptions) {
+		o.maxPriority = maxPriority
+	}
+}
+
+func (o *queueOptions) args() amqp.Table {
+	if o.maxPriority == 0 {
+		return nil
+	}
+	// amqp 把 uint8 编码为有符号的 short-short-int，128 以上会变成负数被 broker 拒绝，因此以 int32 传递
+	return amqp.Table{"x-max-priority": int32(o.maxPriority)}
+}
@@ -0,0 +1,70 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func publishWithPriorities(t *testing.T, ch Channel, priorities []uint8) {
+	noop := func() {}
+	for i, p := range priorities {
+		body := []byte(fmt.Sprintf("%d-%d", p, i))
+		assert.NoError(t, PublishMessageWithConfirm(ch, body, noop, noop, WithPriority(p)))
+	}
+}
+
+func consumeBodies(t *testing.T, ch *memoryChannel, n int) []string {
+	msgs, err := ch.Consume(queueName, "", false, false, false, false, nil)
+	assert.NoError(t, err)
+
+	var bodies []string
+	for len(bodies) < n {
+		select {
+		case d := <-msgs:
+			bodies = append(bodies, string(d.Body))
+			assert.NoError(t, d.Ack(false))
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d messages, got %v", n, bodies)
+		}
+	}
+	return bodies
+}
+
+func TestPriorityQueue_HigherPriorityDeliveredFirst(t *testing.T) {
+	ch := newMemoryChannel()
+	defer ch.Close()
+	DeclareQueueAndExchange(ch, WithMaxPriority(5))
+
+	// 优先级 9 超过队列上限，按 5 处理，与后发布的 5 保持先进先出
+	publishWithPriorities(t, ch, []uint8{0, 3, 1, 9, 5, 3, 0})
+
+	assert.Equal(t, []string{"9-3", "5-4", "3-1", "3-5", "1-2", "0-0", "0-6"}, consumeBodies(t, ch, 7))
+}
+
+func TestPriorityQueue_IgnoredWithoutMaxPriority(t *testing.T) {
+	ch := newMemoryChannel()
+	defer ch.Close()
+	DeclareQueueAndExchange(ch)
+
+	publishWithPriorities(t, ch, []uint8{0, 3, 1})
+
+	assert.Equal(t, []string{"0-0", "3-1", "1-2"}, consumeBodies(t, ch, 3))
+}
+
+func TestPriorityQueue_MaxPriorityAbove127(t *testing.T) {
+	// uint8 会被编码为有符号数，200 到了 broker 变成 -56
+	var options queueOptions
+	WithMaxPriority(200)(&options)
+	assert.Equal(t, int32(200), options.args()["x-max-priority"])
+
+	ch := newMemoryChannel()
+	defer ch.Close()
+	DeclareQueueAndExchange(ch, WithMaxPriority(200))
+
+	publishWithPriorities(t, ch, []uint8{10, 150, 200})
+
+	assert.Equal(t, []string{"200-2", "150-1", "10-0"}, consumeBodies(t, ch, 3))
+}
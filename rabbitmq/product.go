@@ -2,19 +2,42 @@ package rabbitmq
 
 import (
 	"fmt"
+	"log"
 )
 
 func Product() {
 
-	ch, _ := CreateConnAndChannel()
-	// defer conn.Close()
-	defer ch.Close()
+	manager, err := NewConnectionManager(rabbitMQURLs)
+	failOnError(err, "Failed to connect to RabbitMQ")
+	// defer manager.Close()
+	ch, err := manager.Channel()
+	failOnError(err, "Failed to open a channel")
 
 	DeclareQueueAndExchange(ch)
 	go func() {
-		for i := 0; i < 4000; i++ {
+		defer func() {
+			// ReopenChannel 失败时 ch 为 nil
+			if ch != nil {
+				_ = ch.Close()
+			}
+		}()
+		for i := 0; i < 4000; {
 			body := []byte(fmt.Sprintf("RabbitMQ! - %d", i))
-			PublishMessageWithConfirm(ch, body, func() {}, func() {})
+			if err := PublishMessageWithConfirm(ch, body, func() {}, func() {}); err != nil {
+				if !isConnectionError(err) {
+					log.Printf("Failed to publish message %d: %v", i, err)
+					return
+				}
+				// channel 随连接失效，故障转移后在新连接上打开 channel 重新发送
+				log.Printf("Failed to publish message %d: %v, reopening channel", i, err)
+				_ = ch.Close()
+				if ch, err = manager.ReopenChannel(); err != nil {
+					log.Printf("Failed to reopen a channel: %v", err)
+					return
+				}
+				continue
+			}
+			i++
 			// time.Sleep(600 * time.Millisecond)
 		}
 	}()
@@ -0,0 +1,96 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zeromicro/go-zero/core/mapping"
+)
+
+var (
+	ErrSchemaViolation    = errors.New("rabbitmq: message violates schema")
+	ErrUnknownMessageType = errors.New("rabbitmq: unknown message type")
+)
+
+// Validator 校验某一类消息的消息体
+type Validator interface {
+	Validate(body []byte) error
+}
+
+// ValidatorFunc 函数形式的 Validator
+type ValidatorFunc func(body []byte) error
+
+func (f ValidatorFunc) Validate(body []byte) error {
+	return f(body)
+}
+
+// StructSchema 使用 go-zero mapping 的结构体 tag 校验 JSON 消息体，规则与 rest 请求体一致：
+// 没有 optional 的字段必填，支持 options=a|b、range=[0:100]、default= 等；
+// 如果 T 实现了 Validate() error，解析成功后还会调用它做业务校验
+func StructSchema[T any]() Validator {
+	return ValidatorFunc(func(body []byte) error {
+		var v T
+		if err := mapping.UnmarshalJsonBytes(body, &v); err != nil {
+			return err
+		}
+		if validator, ok := any(&v).(interface{ Validate() error }); ok {
+			return validator.Validate()
+		}
+		return nil
+	})
+}
+
+// SchemaOption 注册中心可选项
+type SchemaOption func(*SchemaRegistry)
+
+// WithStrictTypes 未注册的消息类型（包括未设置类型）也视为不合法
+func WithStrictTypes() SchemaOption {
+	return func(r *SchemaRegistry) {
+		r.strict = true
+	}
+}
+
+// SchemaRegistry 按消息类型（amqp.Publishing.Type）登记消息体的校验规则，
+// 生产者和消费者共用同一份定义，避免不同团队对消息格式的理解逐渐偏离
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]Validator
+	strict  bool
+}
+
+// NewSchemaRegistry 创建消息格式注册中心
+func NewSchemaRegistry(opts ...SchemaOption) *SchemaRegistry {
+	r := &SchemaRegistry{
+		schemas: make(map[string]Validator),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register 登记消息类型对应的校验规则，重复登记会覆盖之前的规则
+func (r *SchemaRegistry) Register(messageType string, v Validator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[messageType] = v
+}
+
+// Validate 校验消息体，不合法时返回的错误包装了 ErrSchemaViolation 或 ErrUnknownMessageType
+func (r *SchemaRegistry) Validate(messageType string, body []byte) error {
+	r.mu.RLock()
+	v, ok := r.schemas[messageType]
+	r.mu.RUnlock()
+
+	if !ok {
+		if r.strict {
+			return fmt.Errorf("%w: %q", ErrUnknownMessageType, messageType)
+		}
+		return nil
+	}
+	if err := v.Validate(body); err != nil {
+		return fmt.Errorf("%w: type %q: %v", ErrSchemaViolation, messageType, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type orderCreated struct {
+	OrderID string  `json:"orderId"`
+	Amount  float64 `json:"amount,range=(0:1000000]"`
+	Channel string  `json:"channel,options=app|web"`
+	Remark  string  `json:"remark,optional"`
+}
+
+func (o *orderCreated) Validate() error {
+	if o.OrderID == "0" {
+		return errors.New("orderId must not be zero")
+	}
+	return nil
+}
+
+func newOrderSchemas(opts ...SchemaOption) *SchemaRegistry {
+	schemas := NewSchemaRegistry(opts...)
+	schemas.Register("order.created", StructSchema[orderCreated]())
+	return schemas
+}
+
+func TestSchemaRegistry_Validate(t *testing.T) {
+	schemas := newOrderSchemas()
+
+	cases := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"Valid", `{"orderId":"1","amount":9.9,"channel":"app"}`, true},
+		{"MissingRequired", `{"amount":9.9,"channel":"app"}`, false},
+		{"OutOfRange", `{"orderId":"1","amount":0,"channel":"app"}`, false},
+		{"NotInOptions", `{"orderId":"1","amount":9.9,"channel":"sms"}`, false},
+		{"CustomValidate", `{"orderId":"0","amount":9.9,"channel":"web"}`, false},
+		{"NotJSON", `order 1`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := schemas.Validate("order.created", []byte(c.body))
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrSchemaViolation), "got %v", err)
+			}
+		})
+	}
+
+	// 默认放行未登记的类型，严格模式下拒绝
+	assert.NoError(t, schemas.Validate("order.paid", []byte(`anything`)))
+	err := newOrderSchemas(WithStrictTypes()).Validate("", []byte(`{}`))
+	assert.True(t, errors.Is(err, ErrUnknownMessageType))
+}
+
+func TestPublishMessageWithConfirm_Schema(t *testing.T) {
+	ch := newMemoryChannel()
+	DeclareQueueAndExchange(ch)
+	schemas := newOrderSchemas()
+	noop := func() {}
+
+	err := PublishMessageWithConfirm(ch, []byte(`{"orderId":"1"}`), noop, noop,
+		WithMessageType("order.created"), ValidateOnPublish(schemas))
+	assert.True(t, errors.Is(err, ErrSchemaViolation))
+	assert.Equal(t, 0, ch.depth(queueName))
+
+	err = PublishMessageWithConfirm(ch, []byte(`{"orderId":"1","amount":1,"channel":"web"}`), noop, noop,
+		WithMessageType("order.created"), ValidateOnPublish(schemas))
+	assert.NoError(t, err)
+	d, ok := ch.get(queueName)
+	assert.True(t, ok)
+	assert.Equal(t, "order.created", d.Type)
+}
+
+func TestHandleDelivery_InvalidGoesToDeadLetterQueue(t *testing.T) {
+	ch := newMemoryChannel()
+	DeclareQueueAndExchange(ch)
+	keyring := Keyring{"k1": make([]byte, 32)}
+	noop := func() {}
+
+	// 生产者没有做校验，消息照常入队
+	err := PublishMessageWithConfirm(ch, []byte(`{"orderId":"1","channel":"sms"}`), noop, noop,
+		WithMessageType("order.created"), WithCompression(CompressionGzip), WithEncryption(keyring, "k1"))
+	assert.NoError(t, err)
+
+	d, ok := ch.get(queueName)
+	assert.True(t, ok)
+	deadLetters, err := newDeadLetterPublisher(ch)
+	assert.NoError(t, err)
+	handleDelivery(deadLetters, d, &consumeOptions{keyring: keyring, schemas: newOrderSchemas()})
+
+	assert.Equal(t, []uint64{d.DeliveryTag}, ch.acked)
+	assert.Equal(t, 0, ch.depth(queueName))
+
+	dead, ok := ch.get(deadLetterQueueName)
+	assert.True(t, ok)
+	assert.Contains(t, dead.Headers[headerDeadLetterReason], ErrSchemaViolation.Error())
+	assert.Equal(t, queueName, dead.Headers[headerOriginalQueue])
+	assert.Equal(t, "order.created", dead.Type)
+	// 死信中保留原始编码，加密的消息体不会以明文出现
+	assert.Equal(t, d.Body, dead.Body)
+	assert.Equal(t, "k1", dead.Headers[headerEncryptionKeyID])
+}
+
+func TestHandleDelivery_UndecodableGoesToDeadLetterQueue(t *testing.T) {
+	ch := newMemoryChannel()
+	DeclareQueueAndExchange(ch)
+	noop := func() {}
+
+	err := PublishMessageWithConfirm(ch, []byte(`secret`), noop, noop,
+		WithEncryption(Keyring{"k1": make([]byte, 32)}, "k1"))
+	assert.NoError(t, err)
+
+	d, _ := ch.get(queueName)
+	deadLetters, err := newDeadLetterPublisher(ch)
+	assert.NoError(t, err)
+	handleDelivery(deadLetters, d, &consumeOptions{})
+
+	dead, ok := ch.get(deadLetterQueueName)
+	assert.True(t, ok)
+	assert.Contains(t, dead.Headers[headerDeadLetterReason], ErrUnknownKeyID.Error())
+}
+
+func TestDeadLetter_UnroutableIsRequeued(t *testing.T) {
+	ch := newMemoryChannel()
+	DeclareQueueAndExchange(ch)
+	noop := func() {}
+	err := PublishMessageWithConfirm(ch, []byte(`secret`), noop, noop,
+		WithEncryption(Keyring{"k1": make([]byte, 32)}, "k1"))
+	assert.NoError(t, err)
+
+	deadLetters, err := newDeadLetterPublisher(ch)
+	assert.NoError(t, err)
+	// 死信队列被删除，死信消息被 broker 退回
+	ch.mu.Lock()
+	delete(ch.queues, deadLetterQueueName)
+	ch.mu.Unlock()
+
+	d, _ := ch.get(queueName)
+	handleDelivery(deadLetters, d, &consumeOptions{})
+
+	// 原消息没有被确认，而是放回原队列
+	assert.Empty(t, ch.acked)
+	assert.Equal(t, []uint64{d.DeliveryTag}, ch.nacked)
+	assert.Equal(t, 1, ch.depth(queueName))
+}
+
+func TestNewDeadLetterPublisher_DeclaresQueue(t *testing.T) {
+	ch := newMemoryChannel()
+	_, err := newDeadLetterPublisher(ch)
+	assert.NoError(t, err)
+	_, ok := ch.queues[deadLetterQueueName]
+	assert.True(t, ok)
+}
+
+func TestConsumeMessagesWithAck_ReturnsWhenChannelCloses(t *testing.T) {
+	ch := newMemoryChannel()
+	DeclareQueueAndExchange(ch)
+	noop := func() {}
+	assert.NoError(t, PublishMessageWithConfirm(ch, []byte(`hello`), noop, noop))
+
+	done := make(chan error, 1)
+	go func() { done <- ConsumeMessagesWithAck(ch) }()
+	assert.Eventually(t, func() bool {
+		ch.mu.Lock()
+		defer ch.mu.Unlock()
+		return len(ch.acked) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// channel 随连接失效后消费者退出，调用方可以在新的 channel 上重新注册
+	ch.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeMessagesWithAck should return after the channel is closed")
+	}
+}
@@ -1,6 +1,8 @@
 package rabbitmq
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,8 +14,32 @@ const (
 	queueName    = "example_queue"
 	exchangeName = "example_exchange"
 	routingKey   = "example_key"
+
+	// deadLetterQueueName 死信队列，无法处理的消息通过默认交换机直接投递到这里
+	deadLetterQueueName = queueName + ".dlq"
+	// headerDeadLetterReason 死信消息中记录被拒原因的消息头
+	headerDeadLetterReason = "x-dead-letter-reason"
+	// headerOriginalQueue 死信消息中记录原队列的消息头
+	headerOriginalQueue = "x-original-queue"
 )
 
+// Channel *amqp.Channel 中用到的方法，便于用内存实现替换真实的 broker
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+}
+
+// rabbitMQURLs 集群各节点地址，由 ConnectionManager 负责选择节点和故障转移
+var rabbitMQURLs = []string{
+	rabbitMQURL,
+}
+
 func failOnError(err error, msg string) {
 	if err != nil {
 		log.Fatalf("%s: `%s`", msg, err)
@@ -28,7 +54,12 @@ func CreateConnAndChannel() (*amqp.Channel, *amqp.Connection) {
 	return ch, conn
 }
 
-func DeclareQueueAndExchange(ch *amqp.Channel) {
+func DeclareQueueAndExchange(ch Channel, opts ...QueueOption) {
+	var options queueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	err := ch.ExchangeDeclare(
 		exchangeName,
 		amqp.ExchangeFanout,
@@ -46,7 +77,7 @@ func DeclareQueueAndExchange(ch *amqp.Channel) {
 		false,
 		false,
 		false,
-		nil,
+		options.args(),
 	)
 	failOnError(err, "Failed to declare a queue")
 
@@ -58,11 +89,45 @@ func DeclareQueueAndExchange(ch *amqp.Channel) {
 		nil,
 	)
 	failOnError(err, "Failed to bind queue to exchange")
+
+	err = declareDeadLetterQueue(ch)
+	failOnError(err, "Failed to declare the dead letter queue")
 }
 
-func PublishMessageWithConfirm(ch *amqp.Channel, body []byte, retry, timeout func()) {
-	err := ch.Confirm(false)
-	failOnError(err, "Failed to enable publisher confirms")
+func declareDeadLetterQueue(ch Channel) error {
+	_, err := ch.QueueDeclare(
+		deadLetterQueueName,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	return err
+}
+
+// PublishMessageWithConfirm 发布消息并等待 broker 确认
+// 消息体未通过 ValidateOnPublish 的校验、编码失败或 channel 不可用时返回错误，此时消息不会被发送；
+// channel 随连接失效后需要通过 ConnectionManager.ReopenChannel 换一个新的 channel
+func PublishMessageWithConfirm(ch Channel, body []byte, retry, timeout func(), opts ...PublishOption) error {
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.schemas != nil {
+		if err := options.schemas.Validate(options.messageType, body); err != nil {
+			log.Printf("Refused to publish invalid message: %v", err)
+			return err
+		}
+	}
+	msg, err := encodeBody(body, &options)
+	if err != nil {
+		return fmt.Errorf("encode message body: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("enable publisher confirms: %w", err)
+	}
 
 	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 10000))
 
@@ -72,16 +137,23 @@ func PublishMessageWithConfirm(ch *amqp.Channel, body []byte, retry, timeout fun
 		false,
 		false,
 		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         body,
+			Headers:         msg.Headers,
+			DeliveryMode:    amqp.Persistent,
+			Priority:        options.priority,
+			ContentType:     "text/plain",
+			ContentEncoding: msg.ContentEncoding,
+			Type:            options.messageType,
+			Body:            msg.Body,
 		},
 	)
-	failOnError(err, "Failed to publish a message")
+	if err != nil {
+		return fmt.Errorf("publish message: %w", err)
+	}
 	select {
 	case confirm := <-confirms:
 		if !confirm.Ack {
-			log.Printf("Failed delivery of message with body %s", body)
+			// 消息体可能包含敏感信息，只记录大小和类型
+			log.Printf("Failed delivery of message (%d bytes, type %q)", len(body), options.messageType)
 			// 可以在这里实现重试逻辑
 			retry()
 		}
@@ -90,10 +162,24 @@ func PublishMessageWithConfirm(ch *amqp.Channel, body []byte, retry, timeout fun
 		// 可以在这里实现超时后的处理逻辑
 		timeout()
 	}
-	log.Println("end...", string(body))
+	log.Printf("end... (%d bytes, type %q)", len(body), options.messageType)
+	return nil
 }
 
-func ConsumeMessagesWithAck(ch *amqp.Channel) {
+// ConsumeMessagesWithAck 在 ch 上注册消费者并逐条处理消息，直到 ch 被关闭（例如连接故障转移）才返回；
+// 返回后需要换一个新的 channel 重新调用。注册消费者失败时返回错误
+func ConsumeMessagesWithAck(ch Channel, opts ...ConsumeOption) error {
+	var options consumeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	// 死信队列在消费前声明，否则无法处理的消息会因为路由不到队列而丢失
+	deadLetters, err := newDeadLetterPublisher(ch)
+	if err != nil {
+		return fmt.Errorf("set up the dead letter queue: %w", err)
+	}
+
 	msgs, err := ch.Consume(
 		queueName,
 		"",
@@ -103,28 +189,145 @@ func ConsumeMessagesWithAck(ch *amqp.Channel) {
 		false,
 		nil,
 	)
-	failOnError(err, "Failed to register a consumer")
-
-	forever := make(chan bool, 4)
-
-	go func() {
-		for d := range msgs {
-			log.Printf("Received a message: %s", d.Body)
-			// 在这里处理消息，确保没有发生错误，否则消息可能会被丢失
-			err := processMessage(d.Body)
-			if err == nil {
-				d.Ack(false) // 确认消息已经被处理
-			} else {
-				log.Printf("Error processing message: %v", err)
-				// 可以在这里实现错误处理和重试逻辑
-				// 处理消息失败时，重新发布消息到队列
-				d.Nack(false, true)
-			}
-		}
-	}()
+	if err != nil {
+		return fmt.Errorf("register a consumer: %w", err)
+	}
 
 	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+	for d := range msgs {
+		handleDelivery(deadLetters, d, &options)
+	}
+	return nil
+}
+
+// handleDelivery 处理单条消息：解密解压、校验格式后交给 processMessage
+func handleDelivery(deadLetters *deadLetterPublisher, d amqp.Delivery, opts *consumeOptions) {
+	// 透明地解密、解压，业务处理函数只接触原始消息体
+	body, err := decodeBody(d.Headers, d.ContentEncoding, d.Body, opts)
+	if err != nil {
+		log.Printf("Error decoding message: %v", err)
+		// 无法解码的消息重试也无济于事，直接投递到死信队列
+		deadLetters.deadLetter(d, err)
+		return
+	}
+	if opts.schemas != nil {
+		if err := opts.schemas.Validate(d.Type, body); err != nil {
+			log.Printf("Invalid message: %v", err)
+			deadLetters.deadLetter(d, err)
+			return
+		}
+	}
+
+	// 不记录解密后的消息体，避免敏感信息以明文落入日志
+	log.Printf("Received a message (%d bytes, type %q)", len(body), d.Type)
+	// 在这里处理消息，确保没有发生错误，否则消息可能会被丢失
+	err = processMessage(body)
+	if err == nil {
+		d.Ack(false) // 确认消息已经被处理
+	} else {
+		log.Printf("Error processing message: %v", err)
+		// 可以在这里实现错误处理和重试逻辑
+		// 处理消息失败时，重新发布消息到队列
+		d.Nack(false, true)
+	}
+}
+
+// deadLetterConfirmTimeout 等待死信消息被 broker 确认的最长时间
+const deadLetterConfirmTimeout = 5 * time.Second
+
+// deadLetterPublisher 在消费者的 channel 上发布死信消息，channel 处于 confirm 模式，
+// 死信消息被 broker 确认并且成功路由到死信队列后才确认原消息
+type deadLetterPublisher struct {
+	ch       Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	seq      uint64 // 已发布的死信消息数，即最近一条的 DeliveryTag
+}
+
+// newDeadLetterPublisher 声明死信队列并开启 confirm 模式，ch 上不能再发布其他消息
+func newDeadLetterPublisher(ch Channel) (*deadLetterPublisher, error) {
+	if err := declareDeadLetterQueue(ch); err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	return &deadLetterPublisher{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 16)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 16)),
+	}, nil
+}
+
+// deadLetter 将原始消息（保持原有编码，加密消息不会以明文落入死信队列）连同拒绝原因
+// 发布到死信队列，发布失败时放回原队列稍后再处理
+func (p *deadLetterPublisher) deadLetter(d amqp.Delivery, reason error) {
+	if err := p.publish(d, reason); err != nil {
+		log.Printf("Failed to dead-letter message: %v", err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+func (p *deadLetterPublisher) publish(d amqp.Delivery, reason error) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerDeadLetterReason] = reason.Error()
+	headers[headerOriginalQueue] = queueName
+
+	// 丢弃之前超时的死信消息遗留的退回通知
+	for len(p.returns) > 0 {
+		<-p.returns
+	}
+	// mandatory：死信队列不存在时 broker 退回消息而不是静默丢弃
+	err := p.ch.Publish(
+		"",
+		deadLetterQueueName,
+		true,
+		false,
+		amqp.Publishing{
+			Headers:         headers,
+			DeliveryMode:    amqp.Persistent,
+			Priority:        d.Priority,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			Type:            d.Type,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Body:            d.Body,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	p.seq++
+
+	timer := time.NewTimer(deadLetterConfirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case confirm := <-p.confirms:
+			if confirm.DeliveryTag < p.seq {
+				// 之前超时的死信消息迟到的确认
+				continue
+			}
+			if !confirm.Ack {
+				return errors.New("dead letter message was nacked by the broker")
+			}
+			// broker 先发送 basic.return 再发送 basic.ack，收到确认时退回通知已经送达
+			select {
+			case r := <-p.returns:
+				return fmt.Errorf("dead letter message was returned: %s", r.ReplyText)
+			default:
+				return nil
+			}
+		case <-timer.C:
+			return errors.New("timed out waiting for the dead letter confirmation")
+		}
+	}
 }
 
 func processMessage(body []byte) error {